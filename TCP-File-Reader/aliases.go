@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// aliases maps names clients may send in "alias" to path templates, loaded
+// from the -aliases file, e.g.
+//
+//	{
+//	  "race_log":   "C:\\Redline\\Race.data",
+//	  "server_log": "${LOGDIR}\\server-{date}.log"
+//	}
+var aliases map[string]string
+
+// aliasPlaceholder matches ${ENV_VAR}, {date} and {date:<Go time layout>}.
+var aliasPlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\{date(?::([^}]*))?\}`)
+
+const defaultDateLayout = "2006-01-02"
+
+// AliasInfo describes one alias in a list_aliases response.
+type AliasInfo struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path,omitempty"`
+	Exists   bool       `json:"exists"`
+	Size     int64      `json:"size,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// loadAliases reads the alias table from a JSON object of name to path.
+func loadAliases(file string) (map[string]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var table map[string]string
+	if err := json.Unmarshal(b, &table); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for name, tmpl := range table {
+		if name == "" || tmpl == "" {
+			return nil, fmt.Errorf("%s: alias names and paths must not be empty", file)
+		}
+	}
+	return table, nil
+}
+
+// expandAlias returns the path alias name refers to right now, with
+// environment variables and date placeholders filled in.
+func expandAlias(name string, now time.Time) (string, error) {
+	tmpl, ok := aliases[name]
+	if !ok {
+		return "", fmt.Errorf("unknown alias '%s'", name)
+	}
+
+	var missing []string
+	path := aliasPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		sub := aliasPlaceholder.FindStringSubmatch(m)
+		if sub[1] != "" {
+			v, ok := os.LookupEnv(sub[1])
+			if !ok {
+				missing = append(missing, sub[1])
+			}
+			return v
+		}
+		layout := sub[2]
+		if layout == "" {
+			layout = defaultDateLayout
+		}
+		return now.Format(layout)
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("alias '%s': environment variable %s not set", name, strings.Join(missing, ", "))
+	}
+	return path, nil
+}
+
+// targetPath returns the file a command refers to, either directly or via
+// its alias.
+func targetPath(cmd Command) (string, error) {
+	switch {
+	case cmd.Alias != "" && cmd.File != "":
+		return "", fmt.Errorf("give either file or alias, not both")
+	case cmd.Alias != "":
+		return expandAlias(cmd.Alias, time.Now())
+	}
+	return cmd.File, nil
+}
+
+// listAliases returns every configured alias, sorted by name, with the
+// current state of the file it points at.
+func listAliases() string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	infos := make([]AliasInfo, 0, len(names))
+	for _, name := range names {
+		info := AliasInfo{Name: name}
+		path, err := expandAlias(name, now)
+		if err == nil {
+			info.Path = path
+			path, err = checkPath(path)
+		}
+		if err == nil {
+			var fi os.FileInfo
+			if fi, err = os.Stat(path); err == nil {
+				mod := fi.ModTime()
+				info.Exists = true
+				info.Size = fi.Size()
+				info.Modified = &mod
+			}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			info.Error = err.Error()
+		}
+		infos = append(infos, info)
+	}
+
+	b, _ := json.Marshal(infos)
+	return string(b) + "\n"
+}
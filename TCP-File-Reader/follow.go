@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	followPollInterval = 250 * time.Millisecond
+	followMaxChunk     = 1 << 20 // max bytes read from the file per poll
+	followFingerprint  = 64      // bytes before the offset kept to recognise the file
+)
+
+// follower tracks a file being followed by name. The file is reopened on
+// every poll rather than held open, so the writer is free to rotate or
+// delete it (Windows refuses to rename a file another process has open).
+// Because the file is not held open, a replacement can get the same file
+// identity (ext4 reuses a deleted file's inode at once), so the bytes just
+// before the offset are kept too and must still be there.
+type follower struct {
+	cmd     Command     // re-resolved each poll so dated aliases roll over
+	info    os.FileInfo // identity of the file at the last poll
+	offset  int64       // bytes already sent from the current file
+	tail    []byte      // the last followFingerprint bytes before offset
+	partial []byte      // trailing bytes not yet terminated by a newline
+	missing bool        // path did not exist at the last poll
+	lastErr string
+}
+
+// sameContent reports whether f still holds the bytes fl read last before
+// its offset.
+func (fl *follower) sameContent(f *os.File) bool {
+	if len(fl.tail) == 0 {
+		return true
+	}
+	buf := make([]byte, len(fl.tail))
+	if _, err := f.ReadAt(buf, fl.offset-int64(len(buf))); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, fl.tail)
+}
+
+// advance records that data, read at the offset, has been consumed.
+func (fl *follower) advance(data []byte) {
+	fl.offset += int64(len(data))
+	fl.tail = append(fl.tail, data...)
+	if extra := len(fl.tail) - followFingerprint; extra > 0 {
+		fl.tail = append([]byte(nil), fl.tail[extra:]...)
+	}
+}
+
+// followFile sends the last cmd.Lines lines of the file cmd refers to, framed
+// per fr, and then streams newly appended lines to conn until the client
+// sends 'stop' or disconnects. The returned error is only non-nil if writing
+// to the client fails.
+func followFile(conn net.Conn, fr framing, cmds <-chan string, cmd Command) error {
+	path, err := targetPath(cmd)
+	if err != nil {
+		_, err = fr.writeError(conn, err.Error())
+		return err
+	}
+	real, err := checkPath(path)
+	if err != nil {
+		if errors.Is(err, errAccessDenied) {
+			log.Printf("%s denied: %v", conn.RemoteAddr(), err)
+		}
+		_, err = fr.writeError(conn, err.Error())
+		return err
+	}
+	f, err := os.Open(real)
+	if err != nil {
+		_, err = fr.writeError(conn, err.Error())
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		_, err = fr.writeError(conn, err.Error())
+		return err
+	}
+
+	// Tail only up to the size we just saw so nothing is sent twice
+	fl := &follower{cmd: cmd, info: fi}
+	out, err := tailAt(f, fi.Size(), cmd.Lines)
+	if err == nil {
+		err = fl.skip(f, fi.Size())
+	}
+	f.Close()
+	if err != nil {
+		_, err = fr.writeError(conn, err.Error())
+		return err
+	}
+	if fi.Size() == 0 {
+		out = "" // tailAt gives an empty file as one empty line
+	}
+	// Raw mode has no way to say "nothing yet", so it sends nothing
+	if out != "" || fr.mode != framingRaw {
+		if _, err := fr.writeResult(conn, out); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("%s following %s", conn.RemoteAddr(), path)
+	defer log.Printf("%s stopped following %s", conn.RemoteAddr(), path)
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-cmds:
+			if !ok {
+				return nil // client disconnected
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if isStopCommand(line) {
+				_, err := writeCRLF(conn, "event: stopped\n")
+				return err
+			}
+			if _, err := writeCRLF(conn, "error: follow in progress, send 'stop' first\n"); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			out, err := fl.poll()
+			if err != nil {
+				// Report each distinct error once and keep polling; Windows
+				// briefly denies access while a file is being replaced.
+				if msg := err.Error(); msg != fl.lastErr {
+					fl.lastErr = msg
+					log.Printf("%s follow %s: %v", conn.RemoteAddr(), path, err)
+					if _, err := writeCRLF(conn, fmt.Sprintf("error: %s\n", msg)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			fl.lastErr = ""
+			if out == "" {
+				continue
+			}
+			if _, err := writeCRLF(conn, out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skip starts following f at size, remembering the bytes before it.
+func (fl *follower) skip(f *os.File, size int64) error {
+	n := size
+	if n > followFingerprint {
+		n = followFingerprint
+	}
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, size-n); err != nil {
+		return err
+	}
+	fl.offset, fl.tail = size-n, nil
+	fl.advance(tail)
+	return nil
+}
+
+// poll checks the followed path for truncation or rotation and returns any
+// event lines and complete lines appended since the previous poll.
+func (fl *follower) poll() (string, error) {
+	var out strings.Builder
+
+	// Checked on every poll in case a symlink now points somewhere else
+	path, err := targetPath(fl.cmd)
+	if err != nil {
+		return "", err
+	}
+	var f *os.File
+	real, err := checkPath(path)
+	if err == nil {
+		f, err = os.Open(real)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			if !fl.missing {
+				fl.missing = true
+				out.WriteString("event: missing\n")
+			}
+			return out.String(), nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !os.SameFile(fl.info, fi) || (fi.Size() >= fl.offset && !fl.sameContent(f)):
+		// A new file was put in place under the same name. One that reused
+		// the old file's identity and is shorter than the offset is taken
+		// for a truncation; either way it is sent from the start.
+		if len(fl.partial) > 0 {
+			out.WriteString(strings.TrimSuffix(string(fl.partial), "\r") + "\n")
+			fl.partial = nil
+		}
+		out.WriteString("event: rotated\n")
+		fl.offset, fl.tail = 0, nil
+	case fi.Size() < fl.offset:
+		fl.partial = nil
+		out.WriteString("event: truncated\n")
+		fl.offset, fl.tail = 0, nil
+	}
+	fl.info = fi
+	fl.missing = false
+
+	if fi.Size() <= fl.offset {
+		return out.String(), nil
+	}
+
+	size := fi.Size() - fl.offset
+	if size > followMaxChunk {
+		size = followMaxChunk
+	}
+	chunk, err := io.ReadAll(io.NewSectionReader(f, fl.offset, size))
+	if err != nil {
+		return "", err
+	}
+	fl.advance(chunk)
+
+	data := append(fl.partial, chunk...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		fl.partial = data
+		return out.String(), nil
+	}
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		out.WriteString(strings.TrimSuffix(line, "\r") + "\n")
+	}
+	fl.partial = append([]byte(nil), data[end+1:]...)
+
+	return out.String(), nil
+}
+
+// isStopCommand reports whether line asks to end a follow, either as the
+// bare word 'stop' or as {"action":"stop"}.
+func isStopCommand(line string) bool {
+	if strings.EqualFold(line, "stop") {
+		return true
+	}
+	var cmd Command
+	if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+		return false
+	}
+	return cmd.Action == "stop"
+}
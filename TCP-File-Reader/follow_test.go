@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startFollow follows path from its current end, as followFile does.
+func startFollow(t *testing.T, path string) *follower {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := &follower{cmd: Command{File: path}, info: fi}
+	if err := fl.skip(f, fi.Size()); err != nil {
+		t.Fatal(err)
+	}
+	return fl
+}
+
+func wantPoll(t *testing.T, fl *follower, want string) {
+	t.Helper()
+	got, err := fl.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("poll gave %q, want %q", got, want)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "a1\na2\n")
+	fl := startFollow(t, path)
+
+	wantPoll(t, fl, "")
+	appendFile(t, path, "a3\r\na4")
+	wantPoll(t, fl, "a3\n")
+	appendFile(t, path, "\n")
+	wantPoll(t, fl, "a4\n")
+}
+
+func TestFollowTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "a1\na2\n")
+	fl := startFollow(t, path)
+
+	// Truncated in place, as by copytruncate, and written again
+	writeFile(t, path, "t1\n")
+	wantPoll(t, fl, "event: truncated\nt1\n")
+	appendFile(t, path, "t2\n")
+	wantPoll(t, fl, "t2\n")
+}
+
+func TestFollowRotated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeFile(t, path, "a1\na2\n")
+	fl := startFollow(t, path)
+	appendFile(t, path, "partial")
+	wantPoll(t, fl, "")
+
+	// Deleted and a new file renamed into place
+	os.Remove(path)
+	wantPoll(t, fl, "event: missing\n")
+	next := filepath.Join(dir, "next.log")
+	writeFile(t, next, "r1\nr2\nr3\nr4\nr5\n")
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+	wantPoll(t, fl, "partial\nevent: rotated\nr1\nr2\nr3\nr4\nr5\n")
+}
+
+// A replacement may get the old file's identity back, as ext4 does with a
+// deleted file's inode; its content still gives it away.
+func TestFollowReplacedInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "a1\na2\n")
+	fl := startFollow(t, path)
+
+	writeFile(t, path, "r1\nr2\nr3\n")
+	wantPoll(t, fl, "event: rotated\nr1\nr2\nr3\n")
+	appendFile(t, path, "r4\n")
+	wantPoll(t, fl, "r4\n")
+}
+
+func TestFollowStartsWithTail(t *testing.T) {
+	for _, c := range []struct {
+		name, data, want string
+	}{
+		{"empty file", "", "OK 0 0\r\n"},
+		{"one blank line", "\n", "OK 1 2\r\n\r\n"},
+		{"lines", "a\nb\nc\n", "OK 2 6\r\nb\r\nc\r\n"},
+	} {
+		path := filepath.Join(t.TempDir(), "app.log")
+		writeFile(t, path, c.data)
+
+		server, client := net.Pipe()
+		cmds := make(chan string)
+		done := make(chan error, 1)
+		go func() {
+			done <- followFile(server, framing{mode: framingHeader}, cmds, Command{File: path, Lines: 2})
+		}()
+		r := bufio.NewReader(client)
+		header, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n, size int
+		parseHeader(t, header, &n, &size)
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		if got := header + string(body); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		close(cmds)
+		if err := <-done; err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		client.Close()
+	}
+}
+
+func parseHeader(t *testing.T, header string, lines, size *int) {
+	t.Helper()
+	if _, err := fmt.Sscanf(header, "OK %d %d\r\n", lines, size); err != nil {
+		t.Fatalf("header %q: %v", header, err)
+	}
+}
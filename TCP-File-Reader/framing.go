@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Framing modes for command responses. Raw is the original protocol: the
+// reply is just the content, with no way to tell where it ends.
+const (
+	framingRaw      = "raw"
+	framingHeader   = "header"   // "OK <lines> <bytes>" line, then the content
+	framingSentinel = "sentinel" // the content, then "<sentinel> OK"
+
+	defaultSentinel = "END"
+)
+
+// framing describes how responses on a connection are delimited. Errors are
+// always a single line so clients can tell status from content:
+//
+//	raw:      error: <message>
+//	header:   ERR <message>
+//	sentinel: <sentinel> ERR <message>
+type framing struct {
+	mode     string
+	sentinel string
+}
+
+// with returns f overridden by any framing fields set on cmd.
+func (f framing) with(cmd Command) (framing, error) {
+	if cmd.Framing != "" {
+		switch cmd.Framing {
+		case framingRaw, framingHeader, framingSentinel:
+			f.mode = cmd.Framing
+		default:
+			return f, fmt.Errorf("unsupported framing '%s'", cmd.Framing)
+		}
+	}
+	if cmd.Sentinel != "" {
+		if strings.ContainsAny(cmd.Sentinel, "\r\n") {
+			return f, fmt.Errorf("sentinel must be a single line")
+		}
+		f.sentinel = cmd.Sentinel
+	}
+	return f, nil
+}
+
+// writeResult sends a successful response body to conn.
+func (f framing) writeResult(conn net.Conn, body string) (int, error) {
+	switch f.mode {
+	case framingHeader:
+		s, lines := frameBody(body)
+		return fmt.Fprintf(conn, "OK %d %d\r\n%s", lines, len(s), s)
+	case framingSentinel:
+		s, _ := frameBody(body)
+		return fmt.Fprintf(conn, "%s%s OK\r\n", s, f.sentinel)
+	default:
+		return writeCRLF(conn, body)
+	}
+}
+
+// writeError sends a failed response to conn as a single status line.
+func (f framing) writeError(conn net.Conn, msg string) (int, error) {
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	switch f.mode {
+	case framingHeader:
+		return fmt.Fprintf(conn, "ERR %s\r\n", msg)
+	case framingSentinel:
+		return fmt.Fprintf(conn, "%s ERR %s\r\n", f.sentinel, msg)
+	default:
+		return writeCRLF(conn, fmt.Sprintf("error: %s\n", msg))
+	}
+}
+
+// frameBody converts body to CRLF line endings and counts its lines. Unlike
+// writeCRLF an empty body stays empty, so it is reported as zero lines, and
+// lines that already end in CRLF are not given a second CR.
+func frameBody(body string) (string, int) {
+	if body == "" {
+		return "", 0
+	}
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+	return body, strings.Count(body, "\r\n")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// framed sends body through f.writeResult and returns what the client reads.
+func framed(t *testing.T, f framing, body string) string {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		f.writeResult(server, body)
+		server.Close()
+	}()
+	b, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFrameBody(t *testing.T) {
+	cases := []struct {
+		body  string
+		want  string
+		lines int
+	}{
+		{"", "", 0},
+		{"\n", "\r\n", 1},
+		{"a\nb", "a\r\nb\r\n", 2},
+		{"a\r\nb\r\n", "a\r\nb\r\n", 2},
+		{"a\r\nb\nc", "a\r\nb\r\nc\r\n", 3},
+		{"a\rb\n", "a\rb\r\n", 1},
+	}
+	for _, c := range cases {
+		got, lines := frameBody(c.body)
+		if got != c.want || lines != c.lines {
+			t.Errorf("%q: got %q, %d lines, want %q, %d", c.body, got, lines, c.want, c.lines)
+		}
+	}
+}
+
+// A file with Windows line endings is sent as it is, and the header counts
+// the bytes that follow it.
+func TestFramingCRLFFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "l1\r\nl2\r\nl3\r\n")
+	out, err := readFile(Command{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := framed(t, framing{mode: framingHeader}, out)
+	r := bufio.NewReader(strings.NewReader(resp))
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines, size int
+	parseHeader(t, header, &lines, &size)
+	body, _ := io.ReadAll(r)
+	if lines != 3 || size != len(body) || string(body) != "l1\r\nl2\r\nl3\r\n" {
+		t.Errorf("header %q with body %q", header, body)
+	}
+
+	resp = framed(t, framing{mode: framingSentinel, sentinel: defaultSentinel}, out)
+	if resp != "l1\r\nl2\r\nl3\r\nEND OK\r\n" {
+		t.Errorf("sentinel framing sent %q", resp)
+	}
+}
@@ -0,0 +1,3 @@
+module TCP-File-Reader
+
+go 1.21
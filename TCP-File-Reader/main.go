@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -13,14 +14,52 @@ import (
 )
 
 type Command struct {
-	Action string `json:"action"`
-	Lines  int    `json:"lines"`
-	File   string `json:"file"`
+	Action   string `json:"action"`
+	Lines    int    `json:"lines"`
+	File     string `json:"file"`
+	Alias    string `json:"alias,omitempty"`
+	Framing  string `json:"framing,omitempty"`
+	Sentinel string `json:"sentinel,omitempty"`
+
+	// read_file paging; see readLineRange
+	Mode     string `json:"mode,omitempty"`
+	FromLine int    `json:"from_line,omitempty"`
+	ToLine   int    `json:"to_line,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+
+	// search_file
+	Pattern    string `json:"pattern,omitempty"`
+	IgnoreCase bool   `json:"ignore_case,omitempty"`
+	MaxMatches int    `json:"max_matches,omitempty"`
+	Before     int    `json:"before,omitempty"`
+	After      int    `json:"after,omitempty"`
+
+	// query_races and leaderboard filters
+	Car         string  `json:"car,omitempty"`
+	CarClass    string  `json:"car_class,omitempty"`
+	Track       string  `json:"track,omitempty"`
+	From        string  `json:"from,omitempty"`
+	To          string  `json:"to,omitempty"`
+	MinDistance float64 `json:"min_distance,omitempty"`
+
+	// leaderboard output
+	Top    int    `json:"top,omitempty"`
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+
+	// race_stats grouping
+	GroupBy []string `json:"group_by,omitempty"`
+	Metrics []string `json:"metrics,omitempty"`
 }
 
 func main() {
 	port := flag.Int("p", 9001, "port to listen on")
 	logPath := flag.String("l", "tcp-file-reader.log", "path to log file")
+	rootsFile := flag.String("roots", "", "file listing allowed root directories, one per line")
+	aliasFile := flag.String("aliases", "", "JSON file mapping alias names to file paths")
+	classFile := flag.String("classes", "", "JSON file mapping car class names to CarId patterns")
+	var roots rootList
+	flag.Var(&roots, "root", "allowed root directory (repeatable)")
 
 	flag.Usage = func() {
 		printHelp()
@@ -45,6 +84,29 @@ func main() {
 	log.SetOutput(lf)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	allowedRoots, err = loadRoots(roots, *rootsFile)
+	if err != nil {
+		log.Fatalf("allowed roots: %v", err)
+	}
+	if len(allowedRoots) == 0 {
+		log.Println("Warning: no -root or -roots given, clients may read any file")
+	} else {
+		log.Printf("Allowed roots: %s", strings.Join(allowedRoots, ", "))
+	}
+
+	aliases, err = loadAliases(*aliasFile)
+	if err != nil {
+		log.Fatalf("aliases: %v", err)
+	}
+	if len(aliases) > 0 {
+		log.Printf("Loaded %d aliases from %s", len(aliases), *aliasFile)
+	}
+
+	carClasses, err = loadCarClasses(*classFile)
+	if err != nil {
+		log.Fatalf("car classes: %v", err)
+	}
+
 	addr := fmt.Sprintf(":%d", *port)
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -65,17 +127,15 @@ func main() {
 
 func handleConn(conn net.Conn) {
 	defer conn.Close()
-	r := bufio.NewReader(conn)
+	done := make(chan struct{})
+	defer close(done)
+	lines := readLines(conn, done)
 
-	for {
+	// Connection-wide framing, changed with set_framing
+	conf := framing{mode: framingRaw, sentinel: defaultSentinel}
+
+	for line := range lines {
 		// Expect a single-line JSON command ending with a newline
-		line, err := r.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Println("read error:", err)
-			}
-			return
-		}
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
@@ -83,70 +143,308 @@ func handleConn(conn net.Conn) {
 
 		var cmd Command
 		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
-			writeCRLF(conn, fmt.Sprintf("error: invalid json: %v\n", err))
+			conf.writeError(conn, fmt.Sprintf("invalid json: %v", err))
 			continue
 		}
 
-		if cmd.Action != "read_file" {
-			writeCRLF(conn, fmt.Sprintf("error: unsupported action '%s'\n", cmd.Action))
+		fr, err := conf.with(cmd)
+		if err != nil {
+			conf.writeError(conn, err.Error())
 			continue
 		}
 
-		out, err := tailFile(cmd.File, cmd.Lines)
-		if err != nil {
-			writeCRLF(conn, fmt.Sprintf("error: %v\n", err))
-			continue
+		switch cmd.Action {
+		case "read_file":
+			// Send the result back to the client (use CRLF line endings)
+			out, err := readFile(cmd)
+			if !respond(conn, fr, out, err) {
+				return
+			}
+
+		case "follow_file":
+			// Blocks until the client sends 'stop' or disconnects
+			if err := followFile(conn, fr, lines, cmd); err != nil {
+				log.Println("write error:", err)
+				return
+			}
+
+		case "search_file":
+			out, err := searchFile(cmd)
+			if !respond(conn, fr, out, err) {
+				return
+			}
+
+		case "list_aliases":
+			if !respond(conn, fr, listAliases(), nil) {
+				return
+			}
+
+		case "query_races":
+			out, err := queryRaces(cmd)
+			if !respond(conn, fr, out, err) {
+				return
+			}
+
+		case "leaderboard":
+			out, err := leaderboard(cmd)
+			if !respond(conn, fr, out, err) {
+				return
+			}
+
+		case "race_stats":
+			out, err := raceStats(cmd)
+			if !respond(conn, fr, out, err) {
+				return
+			}
+
+		case "set_framing":
+			conf = fr
+			if !respond(conn, fr, fmt.Sprintf("framing %s\n", fr.mode), nil) {
+				return
+			}
+
+		default:
+			fr.writeError(conn, fmt.Sprintf("unsupported action '%s'", cmd.Action))
 		}
+	}
+}
 
-		// Send the result back to the client (use CRLF line endings)
-		if _, err := writeCRLF(conn, out); err != nil {
-			log.Println("write error:", err)
-			return
+// respond sends the outcome of an action to the client, logging rejected
+// paths with the client address. It returns false once the connection can
+// no longer be written to.
+func respond(conn net.Conn, fr framing, out string, err error) bool {
+	if err != nil {
+		if errors.Is(err, errAccessDenied) {
+			log.Printf("%s denied: %v", conn.RemoteAddr(), err)
 		}
+		fr.writeError(conn, err.Error())
+		return true
+	}
+	if _, err := fr.writeResult(conn, out); err != nil {
+		log.Println("write error:", err)
+		return false
 	}
+	return true
 }
 
+// readLines reads newline-terminated commands from conn in the background so
+// long-running actions such as follow_file can still see 'stop'. The channel
+// is closed when the client disconnects or done is closed.
+func readLines(conn net.Conn, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					log.Println("read error:", err)
+				}
+				return
+			}
+			select {
+			case lines <- line:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// readFile handles read_file: by default the tail of the file, or with
+// "mode":"head" or from_line/to_line a page of it ending in a cursor line.
+func readFile(cmd Command) (string, error) {
+	path, err := targetPath(cmd)
+	if err != nil {
+		return "", err
+	}
+	switch cmd.Mode {
+	case "head", "", "tail":
+	default:
+		return "", fmt.Errorf("unsupported mode '%s'", cmd.Mode)
+	}
+	if err := checkRange(cmd); err != nil {
+		return "", err
+	}
+	if cmd.Mode == "head" {
+		return readHead(path, cmd.Lines, cmd.FromLine, cmd.Offset)
+	}
+
+	if cmd.FromLine > 0 || cmd.ToLine > 0 || cmd.Offset > 0 {
+		from := cmd.FromLine
+		if from == 0 && cmd.Offset == 0 {
+			from = 1
+		}
+		return readLineRange(path, from, cmd.ToLine, cmd.Offset)
+	}
+	return tailFile(path, cmd.Lines)
+}
+
+// tailBlockSize is how much tailFile reads at a time while scanning
+// backwards from the end of a file.
+const tailBlockSize = 64 * 1024
+
+// tailFile returns the last n lines of path, or the whole file if n <= 0.
+// Paths outside the allowed roots are rejected with errAccessDenied.
 func tailFile(path string, n int) (string, error) {
+	path, err := checkPath(path)
+	if err != nil {
+		return "", err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return tailAt(f, fi.Size(), n)
+}
+
+// tailAt returns the last n lines of the first size bytes of r, or all of
+// them if n <= 0. Only the blocks holding those lines are read, so the cost
+// does not depend on the size of the file.
+func tailAt(r io.ReaderAt, size int64, n int) (string, error) {
 	if n <= 0 {
 		// return whole file
-		b, err := io.ReadAll(f)
+		b, err := io.ReadAll(io.NewSectionReader(r, 0, size))
 		if err != nil {
 			return "", err
 		}
 		return string(b), nil
 	}
 
-	scanner := bufio.NewScanner(f)
-	buf := make([]string, 0, n)
-	for scanner.Scan() {
-		buf = append(buf, scanner.Text())
-		if len(buf) > n {
-			buf = buf[1:]
-		}
+	start, err := tailStart(r, size, n)
+	if err != nil {
+		return "", err
 	}
-	if err := scanner.Err(); err != nil {
+	b, err := io.ReadAll(io.NewSectionReader(r, start, size-start))
+	if err != nil {
 		return "", err
 	}
 
-	return strings.Join(buf, "\n") + "\n", nil
+	// Normalise line endings the same way bufio.ScanLines does
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// tailStart seeks backwards from size and returns the offset at which the
+// last n lines begin.
+func tailStart(r io.ReaderAt, size int64, n int) (int64, error) {
+	buf := make([]byte, tailBlockSize)
+	end := size
+
+	// A newline terminating the last line does not start another one
+	if end > 0 {
+		if _, err := r.ReadAt(buf[:1], end-1); err != nil {
+			return 0, err
+		}
+		if buf[0] == '\n' {
+			end--
+		}
+	}
+
+	found := 0
+	for end > 0 {
+		blk := int64(len(buf))
+		if end < blk {
+			blk = end
+		}
+		off := end - blk
+		if read, err := r.ReadAt(buf[:blk], off); err != nil && !(err == io.EOF && int64(read) == blk) {
+			return 0, err
+		}
+		for i := blk - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			found++
+			if found == n {
+				return off + i + 1, nil
+			}
+		}
+		end = off
+	}
+	return 0, nil
 }
 
 func printHelp() {
-	fmt.Println("Usage: tcp-file-reader [-p port] [-l log_file]")
+	fmt.Println("Usage: tcp-file-reader [-p port] [-l log_file] [-root dir]... [-roots file]")
+	fmt.Println("                       [-aliases file] [-classes file]")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -p <port>         Port to listen on (default 9001)")
 	fmt.Println("  -l <log_file>     Path to log file (default tcp-file-reader.log)")
+	fmt.Println("  -root <dir>       Only serve files under dir; repeat for several roots")
+	fmt.Println("  -roots <file>     Read allowed root directories from file, one per line")
+	fmt.Println("                    With no roots configured any readable file is served.")
+	fmt.Println("                    Paths outside the roots get 'access_denied: ...'.")
+	fmt.Println("  -aliases <file>   JSON object of alias name to path, for example")
+	fmt.Println(`                    {"race_log":"C:\\Redline\\Race.data","today":"${LOGDIR}\\{date}.log"}`)
+	fmt.Println("                    ${VAR} is an environment variable, {date} is today as")
+	fmt.Println("                    2006-01-02 and {date:<layout>} uses a Go time layout.")
+	fmt.Println("  -classes <file>   JSON object of car class to CarId patterns, for example")
+	fmt.Println(`                    {"gt3":["*_gt3"],"rally":["*rally*","*_wrc"]}`)
 	fmt.Println("  help, -h, --help  Show this help")
 	fmt.Println()
 	fmt.Println("Send a single-line JSON command over TCP, terminated with CRLF, for example:")
 	fmt.Println(`  {"action":"read_file","lines":3,"file":"C:\\path\\to\\file.txt"}\r\n`)
+	fmt.Println()
+	fmt.Println("To page through a file from the start, use head mode or a line range:")
+	fmt.Println(`  {"action":"read_file","mode":"head","lines":100,"file":"C:\\path\\to\\file.txt"}\r\n`)
+	fmt.Println(`  {"action":"read_file","from_line":101,"to_line":200,"file":"C:\\path\\to\\file.txt"}\r\n`)
+	fmt.Println(`  These end with a line 'cursor: {"line":201,"offset":18342,"eof":false}'.`)
+	fmt.Println(`  Send its line and offset back as "from_line" and "offset" to carry on`)
+	fmt.Println("  from exactly that point without rereading the start of the file.")
+	fmt.Println(`  "lines" goes with head mode; without it, use from_line and to_line.`)
+	fmt.Println()
+	fmt.Println("To search a file with a regular expression (RE2 syntax):")
+	fmt.Println(`  {"action":"search_file","file":"C:\\logs\\server.log","pattern":"window not found",`)
+	fmt.Println(`   "ignore_case":true,"max_matches":20,"before":2,"after":2}\r\n`)
+	fmt.Println("  Matches come back as '<line>:<text>', context as '<line>-<text>', with")
+	fmt.Println("  '--' between groups and a final 'matches: <n>' line.")
+	fmt.Println()
+	fmt.Println(`Use "alias" instead of "file" to read a configured alias, and list them with:`)
+	fmt.Println(`  {"action":"list_aliases"}\r\n`)
+	fmt.Println()
+	fmt.Println("To stream lines as they are appended (like tail -f), use follow_file:")
+	fmt.Println(`  {"action":"follow_file","lines":3,"file":"C:\\path\\to\\file.txt"}\r\n`)
+	fmt.Println("  Send 'stop' (or {\"action\":\"stop\"}) to end following. Truncation and")
+	fmt.Println("  rotation are reported as 'event: truncated' and 'event: rotated' lines.")
+	fmt.Println()
+	fmt.Println("To query race sessions in a Race.data file (all filters optional):")
+	fmt.Println(`  {"action":"query_races","alias":"race_log","car":"bmw_1m_s3","track":"mugello",`)
+	fmt.Println(`   "from":"2025-04-01","to":"2025-04-30","min_distance":1000}\r\n`)
+	fmt.Println("  Replies with one line of JSON: count, results, and errors for bad lines.")
+	fmt.Println()
+	fmt.Println("To rank a track's sessions by best lap (car, car_class and top optional):")
+	fmt.Println(`  {"action":"leaderboard","alias":"race_log","track":"mugello","car_class":"gt3","top":10}\r\n`)
+	fmt.Println(`  Add "width":N to include a fixed-width text table, or "format":"text"`)
+	fmt.Println("  to get only the table, e.g. for a venue display. top may be 1-500 and")
+	fmt.Println("  width 38-200.")
+	fmt.Println()
+	fmt.Println("To total sessions per car, track, day and/or week (filters as above):")
+	fmt.Println(`  {"action":"race_stats","alias":"race_log","group_by":["car","track"],`)
+	fmt.Println(`   "metrics":["sessions","distance","crashes_per_km"]}\r\n`)
+	fmt.Println("  Metrics: sessions, driving_time, distance, fuel_burnt, crashes,")
+	fmt.Println("  crashes_per_km, offroads, max_speed, tyre_wear (default all).")
+	fmt.Println()
+	fmt.Println("Responses are unframed by default. Add \"framing\" to a command, or send")
+	fmt.Println(`  {"action":"set_framing","framing":"header"}\r\n`)
+	fmt.Println("to change it for the rest of the connection:")
+	fmt.Println("  raw       content only; errors as 'error: <message>' (default)")
+	fmt.Println("  header    'OK <lines> <bytes>' then the content; errors as 'ERR <message>'")
+	fmt.Println("  sentinel  content then 'END OK'; errors as 'END ERR <message>'.")
+	fmt.Println("            Set \"sentinel\" to use a marker other than END.")
+	fmt.Println("follow_file frames only the initial lines; streamed lines are always raw.")
 }
 
 // writeCRLF writes the provided string to conn converting LF to CRLF.
@@ -161,4 +459,4 @@ func writeCRLF(conn net.Conn, s string) (int, error) {
 	}
 	s = strings.ReplaceAll(s, "\n", "\r\n")
 	return fmt.Fprint(conn, s)
-}
\ No newline at end of file
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var tailBenchSize = flag.Int64("tail-bench-size", 1<<30, "size in bytes of the file the tail benchmarks generate")
+
+// tailScanner is the scanner loop tailFile used before it read blocks
+// backwards, kept as the reference the block reader must agree with. Its
+// buffer is raised from bufio's 64 KiB default, on which the old loop
+// failed with "token too long", so long lines can be compared too.
+func tailScanner(r io.Reader, n int) (string, error) {
+	if n <= 0 {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	buf := make([]string, 0, n)
+	for scanner.Scan() {
+		buf = append(buf, scanner.Text())
+		if len(buf) > n {
+			buf = buf[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(buf, "\n") + "\n", nil
+}
+
+func TestTailMatchesScanner(t *testing.T) {
+	long := strings.Repeat("x", tailBlockSize+tailBlockSize/2)
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"trailing newline", "one\ntwo\nthree\n"},
+		{"no trailing newline", "one\ntwo\nthree"},
+		{"crlf", "one\r\ntwo\r\nthree\r\n"},
+		{"crlf no trailing newline", "one\r\ntwo\r\nthree"},
+		{"empty file", ""},
+		{"only newline", "\n"},
+		{"blank lines", "\n\none\n\n"},
+		{"line longer than a block", "a\n" + long + "\nb\n" + long},
+		{"lines across blocks", strings.Repeat("0123456789abcdef\n", tailBlockSize/8)},
+	}
+	for _, c := range cases {
+		// 100 is more lines than any case but the last has
+		for _, n := range []int{0, 1, 2, 3, 100, tailBlockSize} {
+			want, err := tailScanner(strings.NewReader(c.data), n)
+			if err != nil {
+				t.Fatalf("%s, n=%d: scanner: %v", c.name, n, err)
+			}
+			got, err := tailAt(strings.NewReader(c.data), int64(len(c.data)), n)
+			if err != nil {
+				t.Fatalf("%s, n=%d: tailAt: %v", c.name, n, err)
+			}
+			if got != want {
+				t.Errorf("%s, n=%d: got %.60q, scanner gave %.60q", c.name, n, got, want)
+			}
+		}
+	}
+}
+
+// benchFile generates, once per run, a file of -tail-bench-size bytes of
+// log-like lines.
+func benchFile(b *testing.B) string {
+	path := filepath.Join(os.TempDir(), "tcp-file-reader-tail-bench.log")
+	if fi, err := os.Stat(path); err == nil && fi.Size() == *tailBenchSize {
+		return path
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriterSize(f, 1<<20)
+	line := "2025-04-01 12:00:00 INFO lap completed car=ks_porsche_911_gt3_r track=mugello\n"
+	for written := int64(0); written < *tailBenchSize; {
+		chunk := line
+		if rest := *tailBenchSize - written; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		w.WriteString(chunk)
+		written += int64(len(chunk))
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+func BenchmarkTailScanner(b *testing.B) {
+	path := benchFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := tailScanner(f, 100); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkTailBlocks(b *testing.B) {
+	path := benchFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tailFile(path, 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
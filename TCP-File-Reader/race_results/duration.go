@@ -0,0 +1,121 @@
+package race_results
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes the .NET TimeSpan
+// constant format used in Race.data, "[-][d.]hh:mm:ss[.fffffff]".
+type Duration time.Duration
+
+// ParseDuration parses a .NET TimeSpan string such as "00:03:56.0660000".
+func ParseDuration(s string) (Duration, error) {
+	orig := s
+	bad := func() (Duration, error) {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+
+	var days int64
+	colon := strings.IndexByte(s, ':')
+	if dot := strings.IndexByte(s, '.'); dot >= 0 && dot < colon {
+		d, err := strconv.ParseInt(s[:dot], 10, 64)
+		if err != nil || d < 0 {
+			return bad()
+		}
+		days = d
+		s = s[dot+1:]
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return bad()
+	}
+	var frac string
+	if dot := strings.IndexByte(parts[2], '.'); dot >= 0 {
+		parts[2], frac = parts[2][:dot], parts[2][dot+1:]
+		if frac == "" || len(frac) > 9 {
+			return bad()
+		}
+	}
+
+	var hms [3]int64
+	limits := [3]int64{24, 60, 60}
+	for i, p := range parts {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || v < 0 || v >= limits[i] {
+			return bad()
+		}
+		hms[i] = v
+	}
+
+	var nanos int64
+	if frac != "" {
+		n, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil || n < 0 {
+			return bad()
+		}
+		nanos = n
+	}
+
+	d := time.Duration(days)*24*time.Hour +
+		time.Duration(hms[0])*time.Hour +
+		time.Duration(hms[1])*time.Minute +
+		time.Duration(hms[2])*time.Second +
+		time.Duration(nanos)
+	if neg {
+		d = -d
+	}
+	return Duration(d), nil
+}
+
+// String formats d the way .NET does, with 100ns precision.
+func (d Duration) String() string {
+	td := time.Duration(d)
+	sign := ""
+	if td < 0 {
+		sign = "-"
+		td = -td
+	}
+	days := td / (24 * time.Hour)
+	td -= days * 24 * time.Hour
+	h := td / time.Hour
+	td -= h * time.Hour
+	m := td / time.Minute
+	td -= m * time.Minute
+	s := td / time.Second
+	ticks := (td - s*time.Second) / 100
+
+	if days > 0 {
+		return fmt.Sprintf("%s%d.%02d:%02d:%02d.%07d", sign, days, h, m, s, ticks)
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d.%07d", sign, h, m, s, ticks)
+}
+
+// Std returns d as a time.Duration.
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	v, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
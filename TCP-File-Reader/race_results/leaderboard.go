@@ -0,0 +1,101 @@
+package race_results
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LeaderboardEntry is one ranked session.
+type LeaderboardEntry struct {
+	Rank      int       `json:"rank"`
+	CarId     string    `json:"CarId"`
+	TrackId   string    `json:"TrackId"`
+	StartedAt time.Time `json:"StartedAt"`
+	BestLap   Duration  `json:"BestLap"`
+	BestLapId int       `json:"BestLapId"`
+	Line      int       `json:"Line"`
+}
+
+// Leaderboard ranks the sessions matching f by best lap, fastest first.
+// Sessions without a timed lap are skipped and equal laps go to whoever set
+// theirs first. At most top entries are returned, or all of them if top <= 0.
+func Leaderboard(results []RaceResult, f Filter, top int) []LeaderboardEntry {
+	var laps []RaceResult
+	for _, r := range results {
+		if r.HasLap() && f.Match(r) {
+			laps = append(laps, r)
+		}
+	}
+	sort.SliceStable(laps, func(i, j int) bool {
+		if laps[i].BestLap != laps[j].BestLap {
+			return laps[i].BestLap < laps[j].BestLap
+		}
+		return laps[i].StartedAt.Before(laps[j].StartedAt)
+	})
+	if top > 0 && len(laps) > top {
+		laps = laps[:top]
+	}
+
+	entries := make([]LeaderboardEntry, len(laps))
+	for i, r := range laps {
+		entries[i] = LeaderboardEntry{
+			Rank:      i + 1,
+			CarId:     r.CarId,
+			TrackId:   r.TrackId,
+			StartedAt: r.StartedAt,
+			BestLap:   r.BestLap,
+			BestLapId: r.BestLapId,
+			Line:      r.Line,
+		}
+	}
+	return entries
+}
+
+// MinLeaderboardWidth is the narrowest table RenderLeaderboard can lay out:
+// it fits the rank, lap and date columns with a few characters of car name.
+const MinLeaderboardWidth = 38
+
+// RenderLeaderboard lays entries out as fixed-width text for a venue
+// display, one line per entry under a title and header, each exactly width
+// characters. Car names are cut to fit. A width below MinLeaderboardWidth
+// is laid out at MinLeaderboardWidth, so callers should reject it.
+func RenderLeaderboard(title string, entries []LeaderboardEntry, width int) string {
+	if width < MinLeaderboardWidth {
+		width = MinLeaderboardWidth
+	}
+	// "POS " + car + " " + lap(11, h:mm:ss.mmm) + " " + date(10)
+	carWidth := width - 4 - 1 - 11 - 1 - 10
+
+	var b strings.Builder
+	b.WriteString(fit(title, width) + "\n")
+	fmt.Fprintf(&b, "%-3s %s %11s %-10s\n", "POS", fit("CAR", carWidth), "LAP", "DATE")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%3d %s %11s %-10s\n",
+			e.Rank, fit(e.CarId, carWidth), FormatLap(e.BestLap), e.StartedAt.Format("2006-01-02"))
+	}
+	return b.String()
+}
+
+// FormatLap formats a lap time as m:ss.mmm, or h:mm:ss.mmm past an hour.
+func FormatLap(d Duration) string {
+	td := d.Std().Round(time.Millisecond)
+	h := td / time.Hour
+	m := (td % time.Hour) / time.Minute
+	s := (td % time.Minute) / time.Second
+	ms := (td % time.Second) / time.Millisecond
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d.%03d", h, m, s, ms)
+	}
+	return fmt.Sprintf("%d:%02d.%03d", m, s, ms)
+}
+
+// fit pads or cuts s to exactly n characters.
+func fit(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
@@ -0,0 +1,101 @@
+package race_results
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestLeaderboard(t *testing.T) {
+	data := strings.Join([]string{
+		`{"CarId":"a_gt3","TrackId":"mugello","StartedAt":"2025-04-03T10:00:00Z","BestLap":"00:01:51"}`,
+		`{"CarId":"b_gt3","TrackId":"mugello","StartedAt":"2025-04-01T10:00:00Z","BestLap":"00:01:50"}`,
+		`{"CarId":"c_gt3","TrackId":"mugello","StartedAt":"2025-04-02T10:00:00Z"}`,
+		`{"CarId":"d_wrc","TrackId":"mugello","StartedAt":"2025-04-02T10:00:00Z","BestLap":"00:01:51"}`,
+		`{"CarId":"e_gt3","TrackId":"monza","StartedAt":"2025-04-02T10:00:00Z","BestLap":"00:01:40"}`,
+	}, "\n")
+	results, _, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mugello := Filter{TrackId: "mugello"}
+
+	cases := []struct {
+		name string
+		f    Filter
+		top  int
+		want []string
+	}{
+		// c has no lap; a and d tie and d set theirs first
+		{"all", mugello, 0, []string{"b_gt3", "d_wrc", "a_gt3"}},
+		{"top", mugello, 2, []string{"b_gt3", "d_wrc"}},
+		{"top above count", mugello, 10, []string{"b_gt3", "d_wrc", "a_gt3"}},
+		{"class", Filter{TrackId: "mugello", CarClass: []string{"*_gt3"}}, 0, []string{"b_gt3", "a_gt3"}},
+		{"no sessions", Filter{TrackId: "spa"}, 0, nil},
+	}
+	for _, c := range cases {
+		entries := Leaderboard(results, c.f, c.top)
+		var cars []string
+		for i, e := range entries {
+			cars = append(cars, e.CarId)
+			if e.Rank != i+1 {
+				t.Errorf("%s: %s ranked %d at position %d", c.name, e.CarId, e.Rank, i+1)
+			}
+		}
+		if !reflect.DeepEqual(cars, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, cars, c.want)
+		}
+	}
+}
+
+func TestRenderLeaderboard(t *testing.T) {
+	started := time.Date(2025, 4, 1, 18, 0, 0, 0, time.UTC)
+	entries := []LeaderboardEntry{
+		{Rank: 1, CarId: "ks_porsche_911_gt3_r_2016", StartedAt: started, BestLap: Duration(110500 * time.Millisecond)},
+		{Rank: 2, CarId: "bmw_m4_gt3", StartedAt: started, BestLap: Duration(time.Hour + 61*time.Second + 5*time.Millisecond)},
+		{Rank: 3, CarId: "ülfs_käfer", StartedAt: started, BestLap: Duration(59 * time.Second)},
+	}
+
+	for _, width := range []int{MinLeaderboardWidth, 48, 200} {
+		text := RenderLeaderboard("Mugello", entries, width)
+		lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+		if len(lines) != 2+len(entries) {
+			t.Fatalf("width %d: %d lines, want %d", width, len(lines), 2+len(entries))
+		}
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n != width {
+				t.Errorf("width %d: %q is %d characters", width, line, n)
+			}
+		}
+	}
+
+	// Car names are cut to what is left of the width
+	text := RenderLeaderboard("Mugello", entries[:2], 44)
+	want := "Mugello                                     \n" +
+		"POS CAR                       LAP DATE      \n" +
+		"  1 ks_porsche_911_gt    1:50.500 2025-04-01\n" +
+		"  2 bmw_m4_gt3        1:01:01.005 2025-04-01\n"
+	if text != want {
+		t.Errorf("got\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestFormatLap(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0:00.000"},
+		{59*time.Second + 999*time.Millisecond, "0:59.999"},
+		{110500 * time.Millisecond, "1:50.500"},
+		{110500*time.Millisecond + 400*time.Microsecond, "1:50.500"},
+		{time.Hour + 61*time.Second + 5*time.Millisecond, "1:01:01.005"},
+	}
+	for _, c := range cases {
+		if got := FormatLap(Duration(c.d)); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.d, got, c.want)
+		}
+	}
+}
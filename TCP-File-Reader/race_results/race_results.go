@@ -0,0 +1,176 @@
+// Package race_results parses the JSONL session records written to
+// Race.data and answers queries over them.
+package race_results
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// RaceResult is one driving session from Race.data. Distances are in
+// metres, speeds in km/h, fuel in litres and airborne figures in seconds.
+type RaceResult struct {
+	CarId           string    `json:"CarId"`
+	TrackId         string    `json:"TrackId"`
+	StartedAt       time.Time `json:"StartedAt"`
+	Time            Duration  `json:"Time"`
+	BestLap         Duration  `json:"BestLap,omitempty"`
+	BestLapId       int       `json:"BestLapId,omitempty"`
+	TotalCrashes    int       `json:"TotalCrashes"`
+	GoneOffroad     int       `json:"GoneOffroad"`
+	Penalties       bool      `json:"Penalties"`
+	MaxSpeed        float64   `json:"MaxSpeed"`
+	Distance        float64   `json:"Distance"`
+	FuelBurnt       float64   `json:"FuelBurnt"`
+	TotalTyreWear   float64   `json:"TotalTyreWear"`
+	LongestAirborne float64   `json:"LongestAirborne,omitempty"`
+	TotalAirborne   float64   `json:"TotalAirborne,omitempty"`
+
+	// Line is the 1-based line of Race.data the record came from.
+	Line int `json:"Line"`
+}
+
+// HasLap reports whether the session completed a timed lap.
+func (r RaceResult) HasLap() bool { return r.BestLap > 0 }
+
+// LineError reports a record that could not be parsed.
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// maxLineSize bounds a single record; real ones are a few hundred bytes.
+const maxLineSize = 1 << 20
+
+// Parse reads every record from r. Malformed lines, including ones longer
+// than maxLineSize, are returned as LineErrors rather than stopping the
+// parse; the error is only non-nil if reading r itself fails.
+func Parse(r io.Reader) ([]RaceResult, []LineError, error) {
+	var results []RaceResult
+	var lineErrs []LineError
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, tooLong, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, lineErrs, fmt.Errorf("line %d: %w", lineNo+1, err)
+		}
+		lineNo++
+		if tooLong {
+			lineErrs = append(lineErrs, LineError{Line: lineNo, Error: fmt.Sprintf("line longer than %d bytes", maxLineSize)})
+			continue
+		}
+		line := strings.TrimSpace(string(raw))
+		if line == "" {
+			continue
+		}
+		res, err := parseRecord(line)
+		if err != nil {
+			lineErrs = append(lineErrs, LineError{Line: lineNo, Error: err.Error()})
+			continue
+		}
+		res.Line = lineNo
+		results = append(results, res)
+	}
+	return results, lineErrs, nil
+}
+
+// readLine reads the next line from br without its line ending. A line
+// longer than maxLineSize is read to its end but not kept, only reported.
+func readLine(br *bufio.Reader) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			return nil, false, err
+		}
+		if !tooLong {
+			if len(line)+len(chunk) > maxLineSize {
+				line, tooLong = nil, true
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if !isPrefix {
+			return line, tooLong, nil
+		}
+	}
+}
+
+func parseRecord(line string) (RaceResult, error) {
+	var res RaceResult
+	if err := json.Unmarshal([]byte(line), &res); err != nil {
+		return res, err
+	}
+	switch {
+	case res.CarId == "":
+		return res, fmt.Errorf("missing CarId")
+	case res.TrackId == "":
+		return res, fmt.Errorf("missing TrackId")
+	case res.StartedAt.IsZero():
+		return res, fmt.Errorf("missing StartedAt")
+	}
+	return res, nil
+}
+
+// Filter selects sessions. Zero fields match everything; car and track
+// compare case-insensitively and the date range is [From, To).
+type Filter struct {
+	CarId       string
+	TrackId     string
+	From        time.Time
+	To          time.Time
+	MinDistance float64
+
+	// CarClass lists glob patterns (as in path.Match) of which a session's
+	// CarId must match at least one, e.g. "*_gt3".
+	CarClass []string
+}
+
+// Match reports whether r passes every condition in f.
+func (f Filter) Match(r RaceResult) bool {
+	switch {
+	case f.CarId != "" && !strings.EqualFold(r.CarId, f.CarId):
+		return false
+	case f.TrackId != "" && !strings.EqualFold(r.TrackId, f.TrackId):
+		return false
+	case !f.From.IsZero() && r.StartedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !r.StartedAt.Before(f.To):
+		return false
+	case r.Distance < f.MinDistance:
+		return false
+	case len(f.CarClass) > 0 && !matchAny(f.CarClass, r.CarId):
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the results that match f, in their original order.
+func Select(results []RaceResult, f Filter) []RaceResult {
+	var out []RaceResult
+	for _, r := range results {
+		if f.Match(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package race_results
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"00:00:00", 0},
+		{"00:03:56.0660000", 3*time.Minute + 56*time.Second + 66*time.Millisecond},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"00:00:01.5", 1500 * time.Millisecond},
+		{"00:00:00.123456789", 123456789},
+		{"2.03:00:00", 51 * time.Hour},
+		{"-00:00:10.2500000", -10250 * time.Millisecond},
+		{"-1.00:00:00", -24 * time.Hour},
+	}
+	for _, c := range cases {
+		got, err := ParseDuration(c.in)
+		if err != nil {
+			t.Errorf("%q: %v", c.in, err)
+			continue
+		}
+		if got.Std() != c.want {
+			t.Errorf("%q: got %v, want %v", c.in, got.Std(), c.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"3:56",
+		"00:03:56.",
+		"00:03:56.0660000000",
+		"24:00:00",
+		"00:60:00",
+		"00:00:60",
+		"-1.-2:00:00",
+		"x.00:00:00",
+		"00:aa:00",
+		"00:00:00:00",
+	} {
+		if d, err := ParseDuration(in); err == nil {
+			t.Errorf("%q: got %v, want an error", in, d.Std())
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00:00.0000000"},
+		{3*time.Minute + 56*time.Second + 66*time.Millisecond, "00:03:56.0660000"},
+		{51*time.Hour + 150, "2.03:00:00.0000001"},
+		{-10250 * time.Millisecond, "-00:00:10.2500000"},
+	}
+	for _, c := range cases {
+		if got := Duration(c.d).String(); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.d, got, c.want)
+		}
+		// It reads back as it was written, to 100ns
+		back, err := ParseDuration(c.want)
+		if err != nil || back.Std() != c.d.Truncate(100) {
+			t.Errorf("%q read back as %v, %v", c.want, back.Std(), err)
+		}
+	}
+
+	b, _ := json.Marshal(Duration(90 * time.Second))
+	if string(b) != `"00:01:30.0000000"` {
+		t.Errorf("JSON %s", b)
+	}
+	var d Duration
+	if err := json.Unmarshal([]byte(`90`), &d); err == nil {
+		t.Error("a number was taken as a duration")
+	}
+}
+
+const (
+	record1 = `{"CarId":"ks_porsche_911_gt3_r","TrackId":"mugello","StartedAt":"2025-04-01T18:00:00+02:00","Time":"00:20:00","BestLap":"00:01:50.5000000","Distance":10000}`
+	record2 = `{"CarId":"ks_audi_r8_lms","TrackId":"monza","StartedAt":"2025-04-02T18:00:00+02:00","Time":"00:10:00","Distance":5000}`
+)
+
+func TestParse(t *testing.T) {
+	long := `{"CarId":"` + strings.Repeat("x", maxLineSize) + `"}`
+	data := strings.Join([]string{
+		record1,
+		"",
+		`not json`,
+		`{"TrackId":"monza","StartedAt":"2025-04-02T18:00:00Z"}`,
+		`{"CarId":"a","StartedAt":"2025-04-02T18:00:00Z"}`,
+		`{"CarId":"a","TrackId":"monza"}`,
+		`{"CarId":"a","TrackId":"monza","StartedAt":"2025-04-02T18:00:00Z","Time":"1:2"}`,
+		long,
+		"  " + record2 + "\r",
+	}, "\n")
+
+	results, lineErrs, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Line != 1 || results[1].Line != 9 {
+		t.Fatalf("got %d results %+v, want lines 1 and 9", len(results), results)
+	}
+	if r := results[0]; r.CarId != "ks_porsche_911_gt3_r" || r.BestLap.Std() != 110500*time.Millisecond || !r.HasLap() {
+		t.Errorf("first result %+v", r)
+	}
+	if results[1].HasLap() {
+		t.Error("session without BestLap has a lap")
+	}
+
+	wantErrs := []struct {
+		line int
+		has  string
+	}{
+		{3, "invalid character"},
+		{4, "missing CarId"},
+		{5, "missing TrackId"},
+		{6, "missing StartedAt"},
+		{7, "invalid duration"},
+		{8, "line longer than"},
+	}
+	if len(lineErrs) != len(wantErrs) {
+		t.Fatalf("got line errors %+v", lineErrs)
+	}
+	for i, w := range wantErrs {
+		if e := lineErrs[i]; e.Line != w.line || !strings.Contains(e.Error, w.has) {
+			t.Errorf("line error %+v, want line %d with %q", e, w.line, w.has)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	results, _, _ := Parse(strings.NewReader(record1 + "\n" + record2 + "\n"))
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	cases := []struct {
+		name string
+		f    Filter
+		want []int // lines
+	}{
+		{"everything", Filter{}, []int{1, 2}},
+		{"car ignores case", Filter{CarId: "KS_AUDI_R8_LMS"}, []int{2}},
+		{"track", Filter{TrackId: "mugello"}, []int{1}},
+		{"from is inclusive", Filter{From: day("2025-04-01")}, []int{1, 2}},
+		{"to is exclusive", Filter{To: time.Date(2025, 4, 2, 16, 0, 0, 0, time.UTC)}, []int{1}},
+		{"min distance", Filter{MinDistance: 6000}, []int{1}},
+		{"class", Filter{CarClass: []string{"*_GT3*", "*_wrc"}}, []int{1}},
+		{"class no match", Filter{CarClass: []string{"*_wrc"}}, nil},
+	}
+	for _, c := range cases {
+		var got []int
+		for _, r := range Select(results, c.f) {
+			got = append(got, r.Line)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got lines %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	data := strings.Join([]string{
+		`{"CarId":"gt3","TrackId":"mugello","StartedAt":"2025-04-14T10:00:00Z","Time":"00:10:00","Distance":10000,"TotalCrashes":2,"MaxSpeed":250,"FuelBurnt":5.5}`,
+		`{"CarId":"gt3","TrackId":"monza","StartedAt":"2025-04-14T20:00:00Z","Time":"00:20:00","Distance":20000,"TotalCrashes":1,"MaxSpeed":300,"FuelBurnt":10}`,
+		`{"CarId":"wrc","TrackId":"mugello","StartedAt":"2025-04-21T10:00:00Z","Time":"00:05:00","Distance":3000,"GoneOffroad":4,"MaxSpeed":180}`,
+	}, "\n")
+	results, _, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		groupBy []string
+		keys    []map[string]string
+		counts  []int
+	}{
+		{nil, []map[string]string{{}}, []int{3}},
+		{[]string{GroupCar}, []map[string]string{{"car": "gt3"}, {"car": "wrc"}}, []int{2, 1}},
+		{[]string{GroupTrack}, []map[string]string{{"track": "monza"}, {"track": "mugello"}}, []int{1, 2}},
+		{[]string{GroupDay}, []map[string]string{{"day": "2025-04-14"}, {"day": "2025-04-21"}}, []int{2, 1}},
+		{[]string{GroupWeek}, []map[string]string{{"week": "2025-W16"}, {"week": "2025-W17"}}, []int{2, 1}},
+		{[]string{GroupCar, GroupTrack},
+			[]map[string]string{{"car": "gt3", "track": "monza"}, {"car": "gt3", "track": "mugello"}, {"car": "wrc", "track": "mugello"}},
+			[]int{1, 1, 1}},
+	}
+	for _, c := range cases {
+		groups, err := Stats(results, Filter{}, c.groupBy)
+		if err != nil {
+			t.Fatalf("%v: %v", c.groupBy, err)
+		}
+		var keys []map[string]string
+		var counts []int
+		for _, g := range groups {
+			keys = append(keys, g.Key)
+			counts = append(counts, g.Sessions)
+		}
+		if !reflect.DeepEqual(keys, c.keys) || !reflect.DeepEqual(counts, c.counts) {
+			t.Errorf("%v: got %v %v, want %v %v", c.groupBy, keys, counts, c.keys, c.counts)
+		}
+	}
+
+	if _, err := Stats(results, Filter{}, []string{"colour"}); err == nil {
+		t.Error("unknown group_by key accepted")
+	}
+
+	groups, _ := Stats(results, Filter{CarId: "gt3"}, nil)
+	report, err := groups[0].Report(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"sessions":       2,
+		"driving_time":   map[string]interface{}{"total": Duration(30 * time.Minute), "avg": Duration(15 * time.Minute)},
+		"distance":       map[string]interface{}{"total": 30000.0, "avg": 15000.0},
+		"fuel_burnt":     map[string]interface{}{"total": 15.5, "avg": 7.75},
+		"crashes":        map[string]interface{}{"total": 3, "avg": 1.5},
+		"crashes_per_km": 0.1,
+		"offroads":       map[string]interface{}{"total": 0, "avg": 0.0},
+		"max_speed":      map[string]interface{}{"max": 300.0, "avg": 275.0},
+		"tyre_wear":      map[string]interface{}{"total": 0.0, "avg": 0.0},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report\n got %v\nwant %v", report, want)
+	}
+	if _, err := groups[0].Report([]string{"sessions", "lap_count"}); err == nil {
+		t.Error("unknown metric accepted")
+	}
+}
@@ -0,0 +1,175 @@
+package race_results
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keys sessions can be grouped by. Days and weeks are taken in the time zone
+// the session was recorded in; weeks are ISO weeks such as "2025-W16".
+const (
+	GroupCar   = "car"
+	GroupTrack = "track"
+	GroupDay   = "day"
+	GroupWeek  = "week"
+)
+
+// Metrics lists the metric names GroupStats.Report understands, in the
+// order they are reported by default.
+var Metrics = []string{
+	"sessions",
+	"driving_time",
+	"distance",
+	"fuel_burnt",
+	"crashes",
+	"crashes_per_km",
+	"offroads",
+	"max_speed",
+	"tyre_wear",
+}
+
+// GroupStats accumulates the sessions sharing one grouping key.
+type GroupStats struct {
+	Key map[string]string
+
+	Sessions    int
+	DrivingTime time.Duration
+	Distance    float64 // metres
+	FuelBurnt   float64 // litres
+	Crashes     int
+	Offroads    int
+	MaxSpeed    float64 // fastest of any session, km/h
+	TyreWear    float64
+
+	sumMaxSpeed float64
+}
+
+func (g *GroupStats) add(r RaceResult) {
+	g.Sessions++
+	g.DrivingTime += r.Time.Std()
+	g.Distance += r.Distance
+	g.FuelBurnt += r.FuelBurnt
+	g.Crashes += r.TotalCrashes
+	g.Offroads += r.GoneOffroad
+	g.TyreWear += r.TotalTyreWear
+	g.sumMaxSpeed += r.MaxSpeed
+	if r.MaxSpeed > g.MaxSpeed {
+		g.MaxSpeed = r.MaxSpeed
+	}
+}
+
+// Stats groups the sessions matching f by the keys in groupBy and totals
+// each group. With no keys every session falls into a single group. Groups
+// are sorted by key.
+func Stats(results []RaceResult, f Filter, groupBy []string) ([]GroupStats, error) {
+	for _, k := range groupBy {
+		switch k {
+		case GroupCar, GroupTrack, GroupDay, GroupWeek:
+		default:
+			return nil, fmt.Errorf("unknown group_by key '%s'", k)
+		}
+	}
+
+	groups := map[string]*GroupStats{}
+	var order []string
+	for _, r := range results {
+		if !f.Match(r) {
+			continue
+		}
+		key := make(map[string]string, len(groupBy))
+		parts := make([]string, len(groupBy))
+		for i, k := range groupBy {
+			key[k] = groupKey(r, k)
+			parts[i] = key[k]
+		}
+		id := strings.Join(parts, "\x00")
+		g, ok := groups[id]
+		if !ok {
+			g = &GroupStats{Key: key}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.add(r)
+	}
+
+	sort.Strings(order)
+	out := make([]GroupStats, len(order))
+	for i, id := range order {
+		out[i] = *groups[id]
+	}
+	return out, nil
+}
+
+func groupKey(r RaceResult, k string) string {
+	switch k {
+	case GroupCar:
+		return r.CarId
+	case GroupTrack:
+		return r.TrackId
+	case GroupDay:
+		return r.StartedAt.Format("2006-01-02")
+	case GroupWeek:
+		y, w := r.StartedAt.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	}
+	return ""
+}
+
+// Report returns the requested metrics for g, keyed by metric name, ready to
+// be encoded as JSON. Totals and averages are reported together; averages
+// are per session. An empty metrics list reports everything.
+func (g GroupStats) Report(metrics []string) (map[string]interface{}, error) {
+	if len(metrics) == 0 {
+		metrics = Metrics
+	}
+	n := float64(g.Sessions)
+	avg := func(total float64) float64 {
+		if n == 0 {
+			return 0
+		}
+		return round(total / n)
+	}
+
+	out := make(map[string]interface{}, len(metrics))
+	for _, m := range metrics {
+		switch m {
+		case "sessions":
+			out[m] = g.Sessions
+		case "driving_time":
+			var mean time.Duration
+			if g.Sessions > 0 {
+				mean = g.DrivingTime / time.Duration(g.Sessions)
+			}
+			out[m] = map[string]interface{}{"total": Duration(g.DrivingTime), "avg": Duration(mean)}
+		case "distance":
+			out[m] = map[string]interface{}{"total": round(g.Distance), "avg": avg(g.Distance)}
+		case "fuel_burnt":
+			out[m] = map[string]interface{}{"total": round(g.FuelBurnt), "avg": avg(g.FuelBurnt)}
+		case "crashes":
+			out[m] = map[string]interface{}{"total": g.Crashes, "avg": avg(float64(g.Crashes))}
+		case "crashes_per_km":
+			var perKm float64
+			if g.Distance > 0 {
+				perKm = round(float64(g.Crashes) / (g.Distance / 1000))
+			}
+			out[m] = perKm
+		case "offroads":
+			out[m] = map[string]interface{}{"total": g.Offroads, "avg": avg(float64(g.Offroads))}
+		case "max_speed":
+			out[m] = map[string]interface{}{"max": g.MaxSpeed, "avg": avg(g.sumMaxSpeed)}
+		case "tyre_wear":
+			out[m] = map[string]interface{}{"total": round(g.TyreWear), "avg": avg(g.TyreWear)}
+		default:
+			return nil, fmt.Errorf("unknown metric '%s'", m)
+		}
+	}
+	return out, nil
+}
+
+// round keeps reports readable; the source data has at most three decimals.
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"TCP-File-Reader/race_results"
+)
+
+// carClasses maps car class names to CarId glob patterns, loaded from the
+// -classes file, e.g. {"gt3":["*_gt3"],"rally":["*rally*","*_wrc"]}.
+var carClasses map[string][]string
+
+// Leaderboard defaults for requests without "top" or "width", and the
+// largest a request may ask for. The narrowest width is the renderer's
+// race_results.MinLeaderboardWidth.
+const (
+	defaultLeaderboardTop   = 10
+	defaultLeaderboardWidth = 48
+	maxLeaderboardTop       = 500
+	maxLeaderboardWidth     = 200
+)
+
+// QueryResponse is the JSON reply to query_races.
+type QueryResponse struct {
+	Count   int                       `json:"count"`
+	Results []race_results.RaceResult `json:"results"`
+	Errors  []race_results.LineError  `json:"errors,omitempty"`
+}
+
+// LeaderboardResponse is the JSON reply to leaderboard.
+type LeaderboardResponse struct {
+	Track   string                          `json:"track"`
+	Car     string                          `json:"car,omitempty"`
+	Class   string                          `json:"car_class,omitempty"`
+	Entries []race_results.LeaderboardEntry `json:"entries"`
+	Text    string                          `json:"text,omitempty"`
+	Errors  []race_results.LineError        `json:"errors,omitempty"`
+}
+
+// StatsGroup is one group in a race_stats reply.
+type StatsGroup struct {
+	Key     map[string]string      `json:"key"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+// StatsResponse is the JSON reply to race_stats.
+type StatsResponse struct {
+	GroupBy []string                 `json:"group_by"`
+	Groups  []StatsGroup             `json:"groups"`
+	Errors  []race_results.LineError `json:"errors,omitempty"`
+}
+
+// loadCarClasses reads the car class table from a JSON object of class name
+// to a list of CarId patterns.
+func loadCarClasses(file string) (map[string][]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var table map[string][]string
+	if err := json.Unmarshal(b, &table); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for name, patterns := range table {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("%s: class '%s': bad pattern '%s'", file, name, p)
+			}
+		}
+	}
+	return table, nil
+}
+
+// loadRaces parses the race data file a command refers to.
+func loadRaces(cmd Command) ([]race_results.RaceResult, []race_results.LineError, error) {
+	name, err := targetPath(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err = checkPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return race_results.Parse(f)
+}
+
+// raceFilter builds the session filter from a command's query fields.
+func raceFilter(cmd Command) (race_results.Filter, error) {
+	from, err := parseDateBound(cmd.From, false)
+	if err != nil {
+		return race_results.Filter{}, fmt.Errorf("invalid from: %v", err)
+	}
+	to, err := parseDateBound(cmd.To, true)
+	if err != nil {
+		return race_results.Filter{}, fmt.Errorf("invalid to: %v", err)
+	}
+	var class []string
+	if cmd.CarClass != "" {
+		var ok bool
+		if class, ok = carClasses[cmd.CarClass]; !ok {
+			return race_results.Filter{}, fmt.Errorf("unknown car class '%s'", cmd.CarClass)
+		}
+	}
+	return race_results.Filter{
+		CarId:       cmd.Car,
+		TrackId:     cmd.Track,
+		From:        from,
+		To:          to,
+		MinDistance: cmd.MinDistance,
+		CarClass:    class,
+	}, nil
+}
+
+// parseDateBound accepts an RFC 3339 timestamp or a plain 2006-01-02 date in
+// local time. A plain date used as an upper bound includes the whole day.
+func parseDateBound(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is not a date or RFC 3339 time", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// queryRaces handles query_races, returning the matching sessions as JSON.
+func queryRaces(cmd Command) (string, error) {
+	filter, err := raceFilter(cmd)
+	if err != nil {
+		return "", err
+	}
+	results, lineErrs, err := loadRaces(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	matched := race_results.Select(results, filter)
+	if matched == nil {
+		matched = []race_results.RaceResult{}
+	}
+	b, err := json.Marshal(QueryResponse{Count: len(matched), Results: matched, Errors: lineErrs})
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// leaderboard handles leaderboard, ranking a track's sessions by best lap.
+// With "format":"text" the reply is only the fixed-width rendering.
+func leaderboard(cmd Command) (string, error) {
+	if cmd.Track == "" {
+		return "", fmt.Errorf("missing track")
+	}
+	if cmd.Format != "" && cmd.Format != "json" && cmd.Format != "text" {
+		return "", fmt.Errorf("unsupported format '%s'", cmd.Format)
+	}
+	if cmd.Top < 0 || cmd.Top > maxLeaderboardTop {
+		return "", fmt.Errorf("top must be between 1 and %d", maxLeaderboardTop)
+	}
+	if cmd.Width != 0 && (cmd.Width < race_results.MinLeaderboardWidth || cmd.Width > maxLeaderboardWidth) {
+		return "", fmt.Errorf("width must be between %d and %d", race_results.MinLeaderboardWidth, maxLeaderboardWidth)
+	}
+	filter, err := raceFilter(cmd)
+	if err != nil {
+		return "", err
+	}
+	results, lineErrs, err := loadRaces(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	top := cmd.Top
+	if top == 0 {
+		top = defaultLeaderboardTop
+	}
+	entries := race_results.Leaderboard(results, filter, top)
+	if entries == nil {
+		entries = []race_results.LeaderboardEntry{}
+	}
+
+	width := cmd.Width
+	if width == 0 {
+		width = defaultLeaderboardWidth
+	}
+	text := race_results.RenderLeaderboard(cmd.Track, entries, width)
+	if cmd.Format == "text" {
+		return text, nil
+	}
+
+	resp := LeaderboardResponse{
+		Track:   cmd.Track,
+		Car:     cmd.Car,
+		Class:   cmd.CarClass,
+		Entries: entries,
+		Errors:  lineErrs,
+	}
+	if cmd.Width > 0 {
+		resp.Text = text
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// raceStats handles race_stats, totalling the matching sessions per group.
+func raceStats(cmd Command) (string, error) {
+	// Reject unknown metrics before reading the file
+	if _, err := (race_results.GroupStats{}).Report(cmd.Metrics); err != nil {
+		return "", err
+	}
+	filter, err := raceFilter(cmd)
+	if err != nil {
+		return "", err
+	}
+	results, lineErrs, err := loadRaces(cmd)
+	if err != nil {
+		return "", err
+	}
+	groups, err := race_results.Stats(results, filter, cmd.GroupBy)
+	if err != nil {
+		return "", err
+	}
+
+	resp := StatsResponse{
+		GroupBy: cmd.GroupBy,
+		Groups:  make([]StatsGroup, 0, len(groups)),
+		Errors:  lineErrs,
+	}
+	if resp.GroupBy == nil {
+		resp.GroupBy = []string{}
+	}
+	for _, g := range groups {
+		metrics, err := g.Report(cmd.Metrics)
+		if err != nil {
+			return "", err
+		}
+		resp.Groups = append(resp.Groups, StatsGroup{Key: g.Key, Metrics: metrics})
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Cursor marks where a head or line-range read stopped. Sending its line and
+// offset back as from_line and offset continues from exactly that point,
+// without rescanning the file, even if it has grown in the meantime.
+type Cursor struct {
+	Line   int   `json:"line"`   // next line to read, 1-based
+	Offset int64 `json:"offset"` // byte offset at which that line starts
+	EOF    bool  `json:"eof"`    // no complete lines remain at the moment
+}
+
+// checkRange rejects read_file arguments that are negative or that would
+// otherwise be silently ignored: in tail mode lines counts back from the end
+// and cannot be combined with a line range, head mode reads lines lines
+// rather than up to to_line, and an offset is only meaningful with the
+// from_line of the cursor it came from.
+func checkRange(cmd Command) error {
+	switch {
+	case cmd.Lines < 0:
+		return fmt.Errorf("lines must not be negative")
+	case cmd.FromLine < 0:
+		return fmt.Errorf("from_line must not be negative")
+	case cmd.ToLine < 0:
+		return fmt.Errorf("to_line must not be negative")
+	case cmd.Offset < 0:
+		return fmt.Errorf("offset must not be negative")
+	case cmd.Offset > 0 && cmd.FromLine == 0:
+		return fmt.Errorf("offset needs the from_line of the cursor it came from")
+	case cmd.Mode == "head" && cmd.ToLine > 0:
+		return fmt.Errorf("to_line cannot be used in head mode; use lines")
+	case cmd.Mode != "head" && cmd.Lines > 0 && (cmd.FromLine > 0 || cmd.ToLine > 0):
+		return fmt.Errorf("lines cannot be combined with from_line or to_line; use \"mode\":\"head\" to read lines lines from a line")
+	}
+	return nil
+}
+
+// readHead handles read_file with "mode":"head": the first n lines of the
+// file, or of the part after a cursor, followed by a cursor line.
+func readHead(path string, n, from int, offset int64) (string, error) {
+	if from <= 0 {
+		from = 1
+	}
+	to := 0
+	if n > 0 {
+		to = from + n - 1
+	}
+	return readLineRange(path, from, to, offset)
+}
+
+// readLineRange returns lines from..to (1-based, inclusive; to <= 0 means
+// to the end of the file) followed by a line "cursor: {...}" holding the
+// Cursor to continue from. If offset is non-zero it must be a previous
+// cursor's offset, the byte at which line from begins.
+//
+// A last line without a newline is sent but the cursor stays at its start,
+// so it is sent again, complete, by the next read.
+func readLineRange(path string, from, to int, offset int64) (string, error) {
+	if from <= 0 {
+		return "", fmt.Errorf("from_line must be 1 or more")
+	}
+	if to > 0 && to < from {
+		return "", fmt.Errorf("to_line %d is before from_line %d", to, from)
+	}
+
+	path, err := checkPath(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := fi.Size()
+
+	line := 1
+	if offset != 0 {
+		if err := checkCursor(f, offset, size); err != nil {
+			return "", err
+		}
+		line = from
+	}
+
+	var out strings.Builder
+	cur := Cursor{Line: line, Offset: offset}
+	br := bufio.NewReader(io.NewSectionReader(f, offset, size-offset))
+	for to <= 0 || cur.Line <= to {
+		s, err := br.ReadString('\n')
+		if err == io.EOF {
+			if s != "" && cur.Line >= from {
+				out.WriteString(strings.TrimSuffix(s, "\r") + "\n")
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if cur.Line >= from {
+			out.WriteString(strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r") + "\n")
+		}
+		cur.Line++
+		cur.Offset += int64(len(s))
+	}
+	cur.EOF = !hasCompleteLine(br)
+
+	b, _ := json.Marshal(cur)
+	out.WriteString("cursor: " + string(b) + "\n")
+	return out.String(), nil
+}
+
+// checkCursor verifies offset is the start of a line within the file.
+func checkCursor(f *os.File, offset, size int64) error {
+	if offset < 0 || offset > size {
+		return fmt.Errorf("offset %d is beyond the end of the file (%d bytes); was it truncated?", offset, size)
+	}
+	var b [1]byte
+	if _, err := f.ReadAt(b[:], offset-1); err != nil {
+		return err
+	}
+	if b[0] != '\n' {
+		return fmt.Errorf("offset %d is not the start of a line", offset)
+	}
+	return nil
+}
+
+// hasCompleteLine reports whether a newline-terminated line remains in br.
+func hasCompleteLine(br *bufio.Reader) bool {
+	_, err := br.ReadSlice('\n')
+	for err == bufio.ErrBufferFull {
+		_, err = br.ReadSlice('\n')
+	}
+	return err == nil
+}
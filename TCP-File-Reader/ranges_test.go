@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitCursor separates a paged read into its lines and its cursor.
+func splitCursor(t *testing.T, out string) (string, Cursor) {
+	t.Helper()
+	i := strings.LastIndex(out, "cursor: ")
+	if i < 0 || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("no cursor line in %q", out)
+	}
+	var cur Cursor
+	if err := json.Unmarshal([]byte(out[i+len("cursor: "):]), &cur); err != nil {
+		t.Fatalf("cursor in %q: %v", out, err)
+	}
+	return out[:i], cur
+}
+
+func TestReadRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "l1\r\nl2\nl3\nl4\nl5")
+
+	cases := []struct {
+		name string
+		cmd  Command
+		want string
+		cur  Cursor
+	}{
+		{"head", Command{Mode: "head", Lines: 2}, "l1\nl2\n", Cursor{Line: 3, Offset: 7}},
+		{"head from a line", Command{Mode: "head", Lines: 2, FromLine: 2}, "l2\nl3\n", Cursor{Line: 4, Offset: 10}},
+		{"head of all", Command{Mode: "head"}, "l1\nl2\nl3\nl4\nl5\n", Cursor{Line: 5, Offset: 13, EOF: true}},
+		{"range", Command{FromLine: 2, ToLine: 3}, "l2\nl3\n", Cursor{Line: 4, Offset: 10}},
+		{"range to the end", Command{FromLine: 4}, "l4\nl5\n", Cursor{Line: 5, Offset: 13, EOF: true}},
+		{"range past the end", Command{FromLine: 9, ToLine: 10}, "", Cursor{Line: 5, Offset: 13, EOF: true}},
+		{"from a cursor", Command{FromLine: 3, Offset: 7}, "l3\nl4\nl5\n", Cursor{Line: 5, Offset: 13, EOF: true}},
+		{"head from a cursor", Command{Mode: "head", Lines: 1, FromLine: 3, Offset: 7}, "l3\n", Cursor{Line: 4, Offset: 10}},
+	}
+	for _, c := range cases {
+		c.cmd.File = path
+		out, err := readFile(c.cmd)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		lines, cur := splitCursor(t, out)
+		if lines != c.want || cur != c.cur {
+			t.Errorf("%s: got %q %+v, want %q %+v", c.name, lines, cur, c.want, c.cur)
+		}
+	}
+}
+
+// Following cursors reads every line once, even as the file grows, and the
+// unterminated last line again once it is complete.
+func TestCursorResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "l1\nl2\nl3")
+
+	var got strings.Builder
+	cmd := Command{File: path, Mode: "head", Lines: 2}
+	for i, grow := range []string{"", "\nl4\n", "l5\n", ""} {
+		appendFile(t, path, grow)
+		out, err := readFile(cmd)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		lines, cur := splitCursor(t, out)
+		if !strings.HasSuffix(lines, "l3\n") || cur.Line != 3 {
+			got.WriteString(lines)
+		}
+		cmd.FromLine, cmd.Offset = cur.Line, cur.Offset
+	}
+	if got.String() != "l1\nl2\nl3\nl4\nl5\n" {
+		t.Errorf("read %q", got.String())
+	}
+}
+
+func TestReadRangeErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "l1\nl2\nl3\n")
+
+	cases := []struct {
+		cmd  Command
+		want string
+	}{
+		{Command{Lines: -1}, "lines must not be negative"},
+		{Command{FromLine: -1}, "from_line must not be negative"},
+		{Command{FromLine: 1, ToLine: -1}, "to_line must not be negative"},
+		{Command{FromLine: 1, Offset: -3}, "offset must not be negative"},
+		{Command{Offset: 3}, "offset needs the from_line"},
+		{Command{Mode: "head", Offset: 3}, "offset needs the from_line"},
+		{Command{Lines: 2, FromLine: 2}, "lines cannot be combined"},
+		{Command{Lines: 2, ToLine: 2}, "lines cannot be combined"},
+		{Command{Mode: "head", Lines: 2, ToLine: 2}, "to_line cannot be used in head mode"},
+		{Command{FromLine: 3, ToLine: 2}, "to_line 2 is before from_line 3"},
+		{Command{FromLine: 2, Offset: 100}, "beyond the end of the file"},
+		{Command{FromLine: 2, Offset: 2}, "not the start of a line"},
+		{Command{Mode: "middle"}, "unsupported mode"},
+	}
+	for _, c := range cases {
+		c.cmd.File = path
+		_, err := readFile(c.cmd)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%+v: got %v, want %q", c.cmd, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// allowedRoots holds the resolved directories clients may read from. When it
+// is empty any file the service account can open may be read.
+var allowedRoots []string
+
+// errAccessDenied is returned for paths outside allowedRoots. Its text is
+// the error code clients see at the start of the message.
+var errAccessDenied = errors.New("access_denied")
+
+// rootList collects repeated -root flags.
+type rootList []string
+
+func (r *rootList) String() string { return strings.Join(*r, ",") }
+
+func (r *rootList) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// loadRoots resolves dirs, plus any listed one per line in file, into the
+// form checkPath compares against.
+func loadRoots(dirs []string, file string) ([]string, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			dirs = append(dirs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	roots := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		real, err := resolvePath(d)
+		if err != nil {
+			return nil, fmt.Errorf("root %s: %w", d, err)
+		}
+		fi, err := os.Stat(real)
+		if err != nil {
+			return nil, fmt.Errorf("root %s: %w", d, err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("root %s: not a directory", d)
+		}
+		roots = append(roots, real)
+	}
+	return roots, nil
+}
+
+// checkPath returns the real path to open for a client supplied path, or an
+// error wrapping errAccessDenied if it lies outside every allowed root once
+// cleaned and with symlinks resolved.
+func checkPath(path string) (string, error) {
+	if len(allowedRoots) == 0 {
+		return path, nil
+	}
+
+	denied := fmt.Errorf("%w: '%s' is outside the allowed roots", errAccessDenied, path)
+	real, err := resolvePath(path)
+	if err != nil {
+		// Only admit that a file is missing if it would have been allowed
+		if parent, ok := resolveExisting(path); !ok || !inRoots(parent) {
+			return "", denied
+		}
+		return "", err
+	}
+	if !inRoots(real) {
+		return "", denied
+	}
+	return real, nil
+}
+
+// resolvePath returns the absolute, cleaned path with symlinks resolved.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(normalizeVolume(path))
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	return normalizeVolume(real), nil
+}
+
+// resolveExisting resolves the longest leading part of path that exists and
+// appends the rest, so a missing file is placed where following the
+// symlinks on its way would put it.
+func resolveExisting(path string) (string, bool) {
+	dir, err := filepath.Abs(normalizeVolume(path))
+	if err != nil {
+		return "", false
+	}
+	var rest []string
+	for {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return normalizeVolume(filepath.Join(append([]string{real}, rest...)...)), true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+		dir = parent
+	}
+}
+
+func inRoots(path string) bool {
+	for _, root := range allowedRoots {
+		if within(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path is root or below it. Paths on different
+// drives or shares never match.
+func within(root, path string) bool {
+	if runtime.GOOS == "windows" {
+		// NTFS paths are case-insensitive
+		root, path = strings.ToLower(root), strings.ToLower(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// normalizeVolume rewrites Windows extended-length and device paths
+// (\\?\C:\x, \\.\C:\x and \\?\UNC\server\share\x) to their ordinary form so
+// they compare equal to the roots. Other device paths are left alone and so
+// never fall inside a root.
+func normalizeVolume(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	path = strings.ReplaceAll(path, "/", `\`)
+	switch {
+	case len(path) >= 8 && strings.EqualFold(path[:8], `\\?\UNC\`):
+		return `\\` + path[8:]
+	case (strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`)) &&
+		len(path) >= 6 && path[5] == ':':
+		return path[4:]
+	}
+	return path
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sandbox makes a root holding a.log and sub/b.log next to an outside
+// directory holding secret.log, allows only the root, and returns both.
+func sandbox(t *testing.T) (root, outside string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, outside = filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(root, "a.log"), "a1\na2\nneedle\n")
+	writeFile(t, filepath.Join(root, "sub", "b.log"), "b1\n")
+	writeFile(t, filepath.Join(outside, "secret.log"), "needle\n")
+
+	roots, err := loadRoots([]string{root}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := allowedRoots
+	allowedRoots = roots
+	t.Cleanup(func() { allowedRoots = saved })
+	return root, outside
+}
+
+// symlink makes link point at target, skipping the test where symlinks
+// need privileges the test lacks.
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	root, outside := sandbox(t)
+	sep := string(filepath.Separator)
+
+	allowed := []string{
+		filepath.Join(root, "a.log"),
+		filepath.Join(root, "sub", "b.log"),
+		root + sep + "sub" + sep + ".." + sep + "a.log",
+		root,
+	}
+	for _, path := range allowed {
+		real, err := checkPath(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if want, _ := resolvePath(path); real != want {
+			t.Errorf("%s: opened %s, want %s", path, real, want)
+		}
+	}
+
+	denied := []string{
+		filepath.Join(outside, "secret.log"),
+		root + sep + ".." + sep + "outside" + sep + "secret.log",
+		root + sep + "sub" + sep + ".." + sep + ".." + sep + "outside" + sep + "secret.log",
+		filepath.Dir(root),
+		root + "2" + sep + "a.log", // shares the root's name as a prefix
+	}
+	for _, path := range denied {
+		if _, err := checkPath(path); !errors.Is(err, errAccessDenied) {
+			t.Errorf("%s: got %v, want access denied", path, err)
+		}
+	}
+
+	// A missing file is only admitted to inside the roots
+	_, err := checkPath(filepath.Join(root, "missing.log"))
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file inside the root: got %v, want not found", err)
+	}
+	for _, path := range []string{
+		filepath.Join(outside, "missing.log"),
+		root + sep + ".." + sep + "outside" + sep + "missing.log",
+	} {
+		_, err := checkPath(path)
+		if !errors.Is(err, errAccessDenied) || os.IsNotExist(err) {
+			t.Errorf("%s: got %v, want access denied", path, err)
+		}
+		if strings.Contains(err.Error(), "no such file") {
+			t.Errorf("%s: error tells the file is missing: %v", path, err)
+		}
+	}
+}
+
+func TestCheckPathSymlinks(t *testing.T) {
+	root, outside := sandbox(t)
+	symlink(t, filepath.Join(outside, "secret.log"), filepath.Join(root, "escape.log"))
+	symlink(t, outside, filepath.Join(root, "outdir"))
+	symlink(t, filepath.Join(root, "sub", "b.log"), filepath.Join(root, "inside.log"))
+
+	for _, path := range []string{
+		filepath.Join(root, "escape.log"),
+		filepath.Join(root, "outdir", "secret.log"),
+		filepath.Join(root, "outdir", "missing.log"),
+	} {
+		if _, err := checkPath(path); !errors.Is(err, errAccessDenied) {
+			t.Errorf("%s: got %v, want access denied", path, err)
+		}
+	}
+	real, err := checkPath(filepath.Join(root, "inside.log"))
+	if want, _ := resolvePath(filepath.Join(root, "sub", "b.log")); err != nil || real != want {
+		t.Errorf("symlink within the root: got %s, %v, want %s", real, err, want)
+	}
+}
+
+// Every action that opens a file goes through checkPath.
+func TestActionsStayInRoots(t *testing.T) {
+	root, outside := sandbox(t)
+	inside, secret := filepath.Join(root, "a.log"), filepath.Join(outside, "secret.log")
+
+	actions := []struct {
+		name string
+		run  func(path string) (string, error)
+	}{
+		{"tail", func(p string) (string, error) { return readFile(Command{File: p, Lines: 1}) }},
+		{"head", func(p string) (string, error) { return readFile(Command{File: p, Mode: "head", Lines: 1}) }},
+		{"range", func(p string) (string, error) { return readFile(Command{File: p, FromLine: 1, ToLine: 1}) }},
+		{"search_file", func(p string) (string, error) { return searchFile(Command{File: p, Pattern: "needle"}) }},
+	}
+	for _, a := range actions {
+		if out, err := a.run(inside); err != nil || out == "" {
+			t.Errorf("%s inside the root: %q, %v", a.name, out, err)
+		}
+		out, err := a.run(secret)
+		if !errors.Is(err, errAccessDenied) {
+			t.Errorf("%s outside the root: got %v, want access denied", a.name, err)
+		}
+		if strings.Contains(out, "needle") {
+			t.Errorf("%s outside the root sent %q", a.name, out)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Limits for search_file requests.
+const (
+	defaultSearchMatches = 100
+	maxSearchMatches     = 10000
+	maxSearchContext     = 50
+)
+
+// searchFile handles search_file. The reply is grep-like: matching lines as
+// "<line>:<text>", context lines as "<line>-<text>", "--" between groups
+// that are not adjacent, and a final "matches: <n>" line, with
+// " (limit reached)" appended if max_matches cut the search short.
+func searchFile(cmd Command) (string, error) {
+	if cmd.Pattern == "" {
+		return "", fmt.Errorf("missing pattern")
+	}
+	pattern := cmd.Pattern
+	if cmd.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	limit := cmd.MaxMatches
+	if limit <= 0 {
+		limit = defaultSearchMatches
+	}
+	if limit > maxSearchMatches {
+		limit = maxSearchMatches
+	}
+	if cmd.Before < 0 || cmd.After < 0 || cmd.Before > maxSearchContext || cmd.After > maxSearchContext {
+		return "", fmt.Errorf("before and after must be between 0 and %d", maxSearchContext)
+	}
+
+	path, err := targetPath(cmd)
+	if err != nil {
+		return "", err
+	}
+	path, err = checkPath(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return grep(f, re, limit, cmd.Before, cmd.After)
+}
+
+// numberedLine is a line held back as possible leading context.
+type numberedLine struct {
+	n    int
+	text string
+}
+
+func grep(r io.Reader, re *regexp.Regexp, limit, before, after int) (string, error) {
+	var out strings.Builder
+	var held []numberedLine // up to 'before' lines preceding the current one
+	matches := 0
+	lastPrinted := 0   // line number of the last line written, 0 if none
+	afterLeft := 0     // trailing context lines still to print
+	truncated := false // a match was found past the limit
+
+	emit := func(n int, sep byte, text string) {
+		if lastPrinted > 0 && n > lastPrinted+1 {
+			out.WriteString("--\n")
+		}
+		fmt.Fprintf(&out, "%d%c%s\n", n, sep, text)
+		lastPrinted = n
+	}
+
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		s, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if s == "" && err == io.EOF {
+			break
+		}
+		text := strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+
+		switch {
+		case matches < limit && re.MatchString(text):
+			for _, h := range held {
+				emit(h.n, '-', h.text)
+			}
+			held = held[:0]
+			emit(n, ':', text)
+			matches++
+			afterLeft = after
+		case afterLeft > 0:
+			emit(n, '-', text)
+			afterLeft--
+			if matches >= limit && re.MatchString(text) {
+				truncated = true
+			}
+		case matches >= limit:
+			// Only another match shows the limit cut anything off
+			if re.MatchString(text) {
+				truncated = true
+			}
+		case before > 0:
+			held = append(held, numberedLine{n, text})
+			if len(held) > before {
+				held = held[1:]
+			}
+		}
+		if truncated && afterLeft == 0 {
+			// Limit passed and its trailing context printed
+			fmt.Fprintf(&out, "matches: %d (limit reached)\n", matches)
+			return out.String(), nil
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	fmt.Fprintf(&out, "matches: %d\n", matches)
+	return out.String(), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGrepLimit(t *testing.T) {
+	re := regexp.MustCompile("X")
+	cases := []struct {
+		data         string
+		limit, after int
+		want         string
+	}{
+		{"X\na\nb", 1, 0, "1:X\nmatches: 1\n"},
+		{"X\na\nX\nb", 1, 0, "1:X\nmatches: 1 (limit reached)\n"},
+		{"X\nX\nb\nc", 1, 2, "1:X\n2-X\n3-b\nmatches: 1 (limit reached)\n"},
+		{"X\na\nb\nc", 1, 2, "1:X\n2-a\n3-b\nmatches: 1\n"},
+	}
+	for _, c := range cases {
+		got, err := grep(strings.NewReader(c.data), re, c.limit, 0, c.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("grep(%q, limit %d, after %d) = %q, want %q", c.data, c.limit, c.after, got, c.want)
+		}
+	}
+}
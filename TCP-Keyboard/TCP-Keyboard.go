@@ -11,29 +11,30 @@ import (
 	"strings"
 	"syscall"
 	"time"
-	"unsafe"
 )
 
 var (
-	user32                   = syscall.NewLazyDLL("user32.dll")
-	enumWindowsProc          = user32.NewProc("EnumWindows")
-	getWindowTextWProc       = user32.NewProc("GetWindowTextW")
-	setForegroundWindowProc  = user32.NewProc("SetForegroundWindow")
-	getForegroundWindowProc  = user32.NewProc("GetForegroundWindow")
-	showWindowProc           = user32.NewProc("ShowWindow")
-	isWindowVisibleProc      = user32.NewProc("IsWindowVisible")
-	procKeybd_event          = user32.NewProc("keybd_event")
-	logFile                  *os.File
-	logFilePath              string = "TCP-Keyboard-server.log" // Default log file path
-	serverListener           net.Listener
+	logFile        *os.File
+	logFilePath    string = "TCP-Keyboard-server.log" // Default log file path
+	backendName    string                             // -backend, empty for the platform default
+	configPath     string                             // -config, empty for the built-in defaults
+	serverListener net.Listener
 )
 
-func init() {
-	// Parse log file path from command line
+// setup parses the command line and opens the log. It is called from main
+// rather than init so that tests do not write to the server log.
+func setup() {
+	// Parse log file path and backend from command line
 	for i := 1; i < len(os.Args); i++ {
 		if os.Args[i] == "-l" && i+1 < len(os.Args) {
 			logFilePath = os.Args[i+1]
 			i++ // Skip the next argument since we used it
+		} else if os.Args[i] == "-backend" && i+1 < len(os.Args) {
+			backendName = os.Args[i+1]
+			i++
+		} else if os.Args[i] == "-config" && i+1 < len(os.Args) {
+			configPath = os.Args[i+1]
+			i++
 		}
 	}
 
@@ -48,6 +49,7 @@ func init() {
 }
 
 func main() {
+	setup()
 	defer logFile.Close()
 
 	// Check for help command line parameter
@@ -57,6 +59,18 @@ func main() {
 	}
 
 	log.Println("Running as console application (recommended to run under NSSM)")
+	var err error
+	backend, err = newBackend(backendName)
+	if err != nil {
+		log.Fatalf("Failed to start backend: %v\n", err)
+	}
+	log.Printf("Using %s backend\n", backend.Name)
+	if configPath != "" {
+		if err := loadConfig(configPath); err != nil {
+			log.Fatalf("Failed to load config: %v\n", err)
+		}
+		log.Printf("Loaded config from %s\n", configPath)
+	}
 	runServer()
 }
 
@@ -97,7 +111,6 @@ func runServer() {
 	}
 }
 
-
 func printStartupInfo() {
 	fmt.Println("\n╔════════════════════════════════════════════════════════════════╗")
 	fmt.Println("║        Redline TCP Keyboard Server - Command Reference        ║")
@@ -106,26 +119,113 @@ func printStartupInfo() {
 	fmt.Println("\n📋 COMMAND LINE PARAMETERS:")
 	fmt.Println("\n  help, -h, --help: Display this help information")
 	fmt.Println("  -l <log_file_path>: Specify custom log file path (default: TCP-Keyboard-server.log)")
+	fmt.Println("  -backend <name>: win32 (Windows), x11 (Linux, uses $DISPLAY) or fake (in-memory, for testing)")
+	fmt.Println("                   Default: " + defaultBackend)
+	fmt.Println("  -config <file>: JSON config with defaults and bounds for request timing, e.g.")
+	fmt.Println("                  {\"timing\":{\"key_down_ms\":{\"default\":50,\"min\":1,\"max\":5000},")
+	fmt.Println("                   \"key_gap_ms\":{...},\"focus_timeout_ms\":{...}}}")
 	fmt.Println("\nExample: .\\TCP-Keyboard.exe -l C:\\logs\\keyboard.log")
 
 	fmt.Println("\n📋 ALLOWED TCP MESSAGE STRUCTURES:")
 
 	fmt.Println("\n1. List Visible Windows (recommended):")
 	fmt.Println("   {\"action\":\"list_visible_windows\"}")
-	fmt.Println("   Response: {\"status\":\"success\",\"windows\":[\"Window1\",\"Window2\",...]}")
+	fmt.Println("   Response: {\"status\":\"success\",\"windows\":[{\"handle\":132456,\"title\":\"Untitled - Notepad\",")
+	fmt.Println("              \"class\":\"Notepad\",\"pid\":4120,\"exe\":\"notepad.exe\",\"visible\":true,\"minimized\":false,")
+	fmt.Println("              \"maximized\":false,\"rect\":{\"left\":0,\"top\":0,\"right\":800,\"bottom\":600}},...]}")
 	fmt.Println("   - Shows only visible windows you can see on screen")
 
 	fmt.Println("\n2. List All Windows:")
-	fmt.Println("   {\"action\":\"list_all_windows\"}")
-	fmt.Println("   Response: {\"status\":\"success\",\"windows\":[\"Window1\",\"Window2\",...]}")
+	fmt.Println("   {\"action\":\"list_windows\"} (or \"list_all_windows\")")
 	fmt.Println("   - Shows all windows including hidden/background processes")
+	fmt.Println("   - Filters (optional, for both): visible_only, has_title (true/false), process (e.g. \"notepad.exe\"),")
+	fmt.Println("     class (e.g. \"Notepad\")")
+	fmt.Println("   - titles_only: true returns the old shape, \"windows\":[\"Window1\",\"Window2\",...]")
 
 	fmt.Println("\n3. Press Keys:")
 	fmt.Println("   {\"action\":\"keypress\",\"window_title\":\"Window Title\",\"keys\":[\"a\",\"b\",\"c\"]}")
-	fmt.Println("   - window_title: Partial match of window title (case-insensitive)")
-	fmt.Println("   - keys: Array of key names to press sequentially")
+	fmt.Println("   - window_title: Partial match of window title (case-insensitive); the front-most match wins")
+	fmt.Println("   - match (instead of window_title): {\"title\":..., \"title_prefix\":..., \"title_regex\":...,")
+	fmt.Println("     \"process\":\"notepad.exe\", \"class\":..., \"handle\":N, \"ambiguity\":...}; all given fields must fit.")
+	fmt.Println("     title and title_prefix are exact; use \"(?i)\" in title_regex to ignore case. If several")
+	fmt.Println("     windows fit, ambiguity \"fail\" (default) returns them as candidates, \"first\" takes the")
+	fmt.Println("     front-most in z-order and \"most_recently_active\" the one active last: the same window")
+	fmt.Println("     unless all are minimized, when it is the one this server focused last")
+	fmt.Println("   - keys: Array of key names to press sequentially; all are checked before any is sent")
+	fmt.Println("   - A combo such as \"ctrl+shift+s\" or \"alt+tab\" holds its modifiers for that chord only")
+	fmt.Println("   - A plain modifier such as \"shift\" is held until the end of the array")
+	fmt.Println("   - {\"down\":\"shift\"} and {\"up\":\"shift\"} press or release a key explicitly")
+	fmt.Println("   - {\"key\":\"w\",\"hold_ms\":500} holds a key, modifier or combo down for 500 ms")
+	fmt.Println("   - mode (optional): \"vk\" (default) sends virtual-key codes; \"scancode\" sends hardware")
+	fmt.Println("     scan codes, for games that read DirectInput or raw input")
+	fmt.Println("   - key_down_ms, key_gap_ms, focus_timeout_ms (optional): how long each key is held, the")
+	fmt.Println("     pause after each key event and how long to wait for focus (defaults 50, 50, 2000)")
+	fmt.Println("   - Requests that send input run one at a time, in arrival order. priority (optional, default 0)")
+	fmt.Println("     lets a request jump ahead of lower ones; max_queue_wait_ms (optional) fails it if it has")
+	fmt.Println("     not started by then. Responses include \"queue\":{\"position\":N,\"wait_ms\":M}, where")
+	fmt.Println("     position is the number of requests that were ahead of it")
 	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Pressed keys...in window...\"}")
 
+	fmt.Println("\n4. Type Text:")
+	fmt.Println("   {\"action\":\"type_text\",\"window_title\":\"Window Title\",\"text\":\"Hello, World!\\n\"}")
+	fmt.Println("   - window_title or match: as for keypress")
+	fmt.Println("   - text: UTF-8 string; capitals, punctuation, spaces, tabs and newlines are typed as keys")
+	fmt.Println("   - Characters with no key are sent as Unicode input where the backend supports it")
+	fmt.Println("   - mode, timing and queue fields (optional): as for keypress")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Typed N characters...\",\"typed\":N,")
+	fmt.Println("              \"skipped\":[{\"position\":0,\"char\":\"…\",\"reason\":\"...\"}]}")
+
+	fmt.Println("\n5. Release All Keys:")
+	fmt.Println("   {\"action\":\"release_all\"}")
+	fmt.Println("   - Emergency reset: sends key-up for every modifier key, without waiting in the queue")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Released all modifier keys\",\"released\":[...]}")
+
+	fmt.Println("\n6. Manage Windows:")
+	fmt.Println("   {\"action\":\"focus_window\",\"window_title\":\"Window Title\"}")
+	fmt.Println("   - Also minimize_window, maximize_window, restore_window and close_window (asks the")
+	fmt.Println("     application to close, as its close button would)")
+	fmt.Println("   {\"action\":\"move_resize_window\",\"match\":{...},\"x\":0,\"y\":0,\"width\":1920,\"height\":1080}")
+	fmt.Println("   - x, y, width, height: any left out keep their current value")
+	fmt.Println("   - window_title or match, focus_timeout_ms and queue fields: as for keypress")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Minimized window...\",\"window\":{...}}")
+
+	fmt.Println("\n7. Launch And Supervise Apps:")
+	fmt.Println("   {\"action\":\"launch_app\",\"app\":\"sim\",\"args\":[...],\"working_dir\":\"C:\\\\Games\",")
+	fmt.Println("    \"wait_for_title\":\"^Sim\",\"wait_timeout_ms\":30000}")
+	fmt.Println("   - app: an id from the \"apps\" section of the -config file, e.g.")
+	fmt.Println("     {\"apps\":{\"sim\":{\"path\":\"C:\\\\Games\\\\sim.exe\",\"args\":[],\"dir\":\"\",\"allow_extra_args\":false,")
+	fmt.Println("      \"working_dirs\":[\"C:\\\\Games\"]}}}")
+	fmt.Println("   - args are only accepted if the app has allow_extra_args, and working_dir only if it is")
+	fmt.Println("     one of the app's \"working_dirs\"")
+	fmt.Println("   - wait_for_title (optional): regex; waits for a new window with a matching title")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Launched...\",\"pid\":N,\"window_handle\":N,\"window\":{...}}")
+	fmt.Println("   {\"action\":\"list_processes\",\"app\":\"sim\"}")
+	fmt.Println("   - Processes running a configured app's path (app optional), plus those launch_app started")
+	fmt.Println("   {\"action\":\"terminate_process\",\"pid\":N,\"graceful_ms\":5000}")
+	fmt.Println("   - Only processes launch_app started or running an app's configured path. graceful_ms")
+	fmt.Println("     (optional) first asks its windows to close and waits that long before killing it")
+
+	fmt.Println("\n8. Mouse:")
+	fmt.Println("   {\"action\":\"mouse_click\",\"window_title\":\"Window Title\",\"x\":200,\"y\":150,\"button\":\"left\"}")
+	fmt.Println("   - With window_title or match, the window is focused and x, y are relative to its client area")
+	fmt.Println("     wherever it is now; without, they are screen coordinates")
+	fmt.Println("   - mouse_move: x, y")
+	fmt.Println("   - mouse_click: button left (default), right or middle; double: true; x, y optional")
+	fmt.Println("   - mouse_drag: button, from x, y to to_x, to_y")
+	fmt.Println("   - mouse_scroll: delta_y notches up (negative down), delta_x right (negative left); x, y optional")
+	fmt.Println("   - key_down_ms holds each click, key_gap_ms pauses after each event; queue fields as for keypress")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Clicked left button at (200, 150) in window...\"}")
+
+	fmt.Println("\n9. Wait For A Window:")
+	fmt.Println("   {\"action\":\"wait_for_window\",\"match\":{\"process\":\"sim.exe\"},\"condition\":\"appear\",")
+	fmt.Println("    \"wait_timeout_ms\":60000}")
+	fmt.Println("   - window_title or match: as for keypress")
+	fmt.Println("   - condition: appear (default), disappear, foreground (a matching window has focus) or")
+	fmt.Println("     title_change (the window that matches now gets another title)")
+	fmt.Println("   - wait_timeout_ms (optional): default 30000, at most 300000. Does not wait in the input queue")
+	fmt.Println("   Response: {\"status\":\"success\",\"message\":\"Window...appeared\",\"window\":{...},\"waited_ms\":N}")
+	fmt.Println("   - title_change also returns previous_title; disappear returns the last window seen, if any")
+
 	fmt.Println("\n⌨️  ACCEPTED KEYS:")
 	allowedKeys := getAllowedKeys()
 
@@ -186,47 +286,39 @@ func printStartupInfo() {
 	fmt.Println("")
 }
 
-func getAllowedKeys() map[string][]string {
-	return map[string][]string{
-		"alphabet":  {"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z"},
-		"numbers":   {"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
-		"function":  {"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9", "f10", "f11", "f12"},
-		"control":   {"enter", "return", "tab", "backspace", "space", "escape", "delete", "insert", "home", "end", "pageup", "pagedown"},
-		"arrows":    {"left", "up", "right", "down"},
-		"modifiers": {"shift", "ctrl", "control", "alt", "capslock", "caps", "numlock", "scroll", "menu", "super", "win"},
-		"special":   {"!", "@", "#", "$", "%", "^", "&", "*", "(", ")", "-", "_", "=", "+", "[", "{", "]", "}", ";", ":", "'", "\"", ",", "<", ".", ">", "/", "?", "`", "~"},
-		"numpad":    {"numpad0", "numpad1", "numpad2", "numpad3", "numpad4", "numpad5", "numpad6", "numpad7", "numpad8", "numpad9", "numpad*", "numpad+", "numpad-", "numpad.", "numpad/"},
-	}
-}
-
-func isModifierKey(key string) bool {
-	switch key {
-	case "shift", "ctrl", "control", "alt", "capslock", "caps", "numlock", "scroll", "menu", "super", "win":
-		return true
-	}
-	return false
+type ListWindowsRequest struct {
+	Action     string `json:"action"`
+	TitlesOnly bool   `json:"titles_only"` // the old shape: just the titles, as strings
+	WindowFilter
 }
 
-func waitForForeground(hwnd syscall.Handle, timeout time.Duration) bool {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		fg, _, _ := getForegroundWindowProc.Call()
-		if syscall.Handle(fg) == hwnd {
-			return true
-		}
-		time.Sleep(25 * time.Millisecond)
-	}
-	return false
+type KeypressRequest struct {
+	Action string    `json:"action"`
+	Keys   []KeyStep `json:"keys"`
+	Mode   string    `json:"mode"`
+	WindowTarget
+	TimingRequest
+	QueueRequest
 }
 
-type ListWindowsRequest struct {
+type WindowActionRequest struct {
 	Action string `json:"action"`
+	X      *int32 `json:"x"` // move_resize_window; fields left out keep their current value
+	Y      *int32 `json:"y"`
+	Width  *int32 `json:"width"`
+	Height *int32 `json:"height"`
+	WindowTarget
+	TimingRequest
+	QueueRequest
 }
 
-type KeypressRequest struct {
-	Action      string   `json:"action"`
-	WindowTitle string   `json:"window_title"`
-	Keys        []string `json:"keys"`
+type TypeTextRequest struct {
+	Action string `json:"action"`
+	Text   string `json:"text"`
+	Mode   string `json:"mode"`
+	WindowTarget
+	TimingRequest
+	QueueRequest
 }
 
 func parseMessage(message string) string {
@@ -245,19 +337,15 @@ func parseMessage(message string) string {
 
 	// Route based on action
 	switch actionOnly.Action {
-	case "list_visible_windows":
+	case "list_windows", "list_all_windows", "list_visible_windows":
 		var req ListWindowsRequest
 		if err := json.Unmarshal([]byte(message), &req); err != nil {
-			return toJSON("error", "Invalid list_visible_windows request: "+err.Error(), nil)
+			return toJSON("error", "Invalid "+actionOnly.Action+" request: "+err.Error(), nil)
 		}
-		return handleListVisibleWindows()
-
-	case "list_all_windows":
-		var req ListWindowsRequest
-		if err := json.Unmarshal([]byte(message), &req); err != nil {
-			return toJSON("error", "Invalid list_all_windows request: "+err.Error(), nil)
+		if req.Action == "list_visible_windows" {
+			req.VisibleOnly = true
 		}
-		return handleListAllWindows()
+		return handleListWindows(req)
 
 	case "keypress":
 		var req KeypressRequest
@@ -265,407 +353,388 @@ func parseMessage(message string) string {
 			return toJSON("error", "Invalid keypress request: "+err.Error(), nil)
 		}
 		// Validate required fields
-		if req.WindowTitle == "" {
-			return toJSON("error", "Missing window_title field", nil)
+		if err := req.WindowTarget.check(); err != nil {
+			return toJSON("error", err.Error(), nil)
 		}
 		if len(req.Keys) == 0 {
 			return toJSON("error", "Missing or empty keys array", nil)
 		}
-		return handleKeypress(req.WindowTitle, req.Keys)
+		timing, err := req.resolve()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		// Resolve the keys now, so a bad one is reported without waiting in
+		// the queue
+		plan, err := planSteps(req.Keys, timing)
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		return executor.do(req.QueueRequest, func() string {
+			return handleKeypress(req.WindowTarget, plan, req.Mode, timing)
+		})
+
+	case "type_text":
+		var req TypeTextRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid type_text request: "+err.Error(), nil)
+		}
+		if err := req.WindowTarget.check(); err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		if req.Text == "" {
+			return toJSON("error", "Missing or empty text field", nil)
+		}
+		timing, err := req.resolve()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		return executor.do(req.QueueRequest, func() string {
+			return handleTypeText(req.WindowTarget, req.Text, req.Mode, timing)
+		})
+
+	case "focus_window", "minimize_window", "maximize_window", "restore_window", "close_window", "move_resize_window":
+		var req WindowActionRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid "+actionOnly.Action+" request: "+err.Error(), nil)
+		}
+		if err := req.WindowTarget.check(); err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		timing, err := req.resolve()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		return executor.do(req.QueueRequest, func() string {
+			return handleWindowAction(req, timing)
+		})
+
+	case "mouse_move", "mouse_click", "mouse_drag", "mouse_scroll":
+		var req MouseRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid "+actionOnly.Action+" request: "+err.Error(), nil)
+		}
+		button, err := req.check()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		timing, err := req.resolve()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		return executor.do(req.QueueRequest, func() string {
+			return handleMouse(req, button, timing)
+		})
+
+	case "wait_for_window":
+		var req WaitForWindowRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid wait_for_window request: "+err.Error(), nil)
+		}
+		if err := req.WindowTarget.check(); err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		timeout, err := req.timeout()
+		if err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+		return handleWaitForWindow(req, timeout)
+
+	case "launch_app":
+		var req LaunchAppRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid launch_app request: "+err.Error(), nil)
+		}
+		if req.App == "" {
+			return toJSON("error", "Missing app field", nil)
+		}
+		return handleLaunchApp(req)
+
+	case "list_processes":
+		var req ListProcessesRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid list_processes request: "+err.Error(), nil)
+		}
+		return handleListProcesses(req)
+
+	case "terminate_process":
+		var req TerminateProcessRequest
+		if err := json.Unmarshal([]byte(message), &req); err != nil {
+			return toJSON("error", "Invalid terminate_process request: "+err.Error(), nil)
+		}
+		return handleTerminateProcess(req)
+
+	case "release_all":
+		return handleReleaseAll()
 
 	default:
 		return toJSON("error", "Unknown action: "+actionOnly.Action, nil)
 	}
 }
 
-func handleListVisibleWindows() string {
+func handleListWindows(req ListWindowsRequest) string {
+	windows, err := backend.Windows.Windows()
+	if err != nil {
+		return toJSON("error", "Failed to list windows: "+err.Error(), nil)
+	}
+
+	var matched []Window
 	var windowTitles []string
-	enumWindowsProc.Call(syscall.NewCallback(func(h syscall.Handle, lparam uintptr) uintptr {
-		// Only include visible windows
-		visible, _, _ := isWindowVisibleProc.Call(uintptr(h))
-		if visible == 0 {
-			return 1 // Skip hidden windows
+	for _, w := range windows {
+		if !req.Match(w) {
+			continue
 		}
-
-		var title [256]uint16
-		getWindowTextWProc.Call(uintptr(h), uintptr(unsafe.Pointer(&title[0])), uintptr(len(title)))
-		windowTitle := syscall.UTF16ToString(title[:])
-		// Only include windows with titles
-		if windowTitle != "" {
-			windowTitles = append(windowTitles, windowTitle)
+		matched = append(matched, w)
+		if w.Title != "" {
+			windowTitles = append(windowTitles, w.Title)
 		}
-		return 1 // Continue enumeration
-	}), 0)
+	}
 
 	response := map[string]interface{}{
 		"status":  "success",
-		"windows": windowTitles,
+		"windows": matched,
+	}
+	if req.TitlesOnly {
+		// Untitled windows were never listed in this form
+		response["windows"] = windowTitles
 	}
 	jsonResp, _ := json.Marshal(response)
 	return string(jsonResp)
 }
 
-func handleListAllWindows() string {
-	var windowTitles []string
-	enumWindowsProc.Call(syscall.NewCallback(func(h syscall.Handle, lparam uintptr) uintptr {
-		var title [256]uint16
-		getWindowTextWProc.Call(uintptr(h), uintptr(unsafe.Pointer(&title[0])), uintptr(len(title)))
-		windowTitle := syscall.UTF16ToString(title[:])
-		// Include all windows with titles (both visible and hidden)
-		if windowTitle != "" {
-			windowTitles = append(windowTitles, windowTitle)
+// focusTarget finds the target window and brings it to the foreground
+// within timeout. If that fails it returns the error response to send
+// instead.
+func focusTarget(target WindowTarget, timeout time.Duration) (Window, string) {
+	w, errResp := findWindow(target)
+	if errResp != "" {
+		return Window{}, errResp
+	}
+
+	if !focusWindow(w, timeout) {
+		// Not fatal, but very useful to log
+		log.Printf("Warning: target window did not become foreground: '%s'\n", target)
+		return Window{}, toJSON("error", fmt.Sprintf("Failed to focus window: '%s'", target), nil)
+	}
+	noteActivated(w.Handle)
+	return w, ""
+}
+
+func handleKeypress(target WindowTarget, plan []plannedStep, mode string, timing Timing) (resp string) {
+	sender, err := newKeySender(mode, timing)
+	if err != nil {
+		return toJSON("error", err.Error(), nil)
+	}
+	w, errResp := focusTarget(target, timing.FocusTimeout)
+	if errResp != "" {
+		return errResp
+	}
+
+	// Whatever happens, nothing is left held down
+	defer sender.guard(&resp)
+
+	var pressedKeys []string
+	for _, p := range plan {
+		switch p.kind {
+		case stepDown:
+			err = sender.down(p.key)
+			sender.gap()
+		case stepUp:
+			err = sender.up(p.key)
+			sender.gap()
+		default:
+			// Regular key or combo: press and release
+			err = sender.chord(p.chord, p.hold)
 		}
-		return 1 // Continue enumeration
-	}), 0)
+		if err != nil {
+			return toJSON("error", fmt.Sprintf("Failed to send %s: %v", p.label, err), map[string]interface{}{"pressed": pressedKeys})
+		}
+		pressedKeys = append(pressedKeys, p.label)
+	}
+
+	// Release all held modifier keys at the end
+	if err := sender.releaseAll(); err != nil {
+		return toJSON("error", "Failed to release held keys: "+err.Error(), nil)
+	}
 
 	response := map[string]interface{}{
 		"status":  "success",
-		"windows": windowTitles,
+		"message": fmt.Sprintf("Pressed keys %v in window '%s'", pressedKeys, windowLabel(target, w)),
 	}
 	jsonResp, _ := json.Marshal(response)
 	return string(jsonResp)
 }
 
-func handleKeypress(windowTitle string, keys []string) string {
-	var hwnd syscall.Handle
-	var allWindows []string
-
-	enumWindowsProc.Call(syscall.NewCallback(func(h syscall.Handle, lparam uintptr) uintptr {
-		var title [256]uint16
-		getWindowTextWProc.Call(uintptr(h), uintptr(unsafe.Pointer(&title[0])), uintptr(len(title)))
-		windowTitleStr := syscall.UTF16ToString(title[:])
+func handleWindowAction(req WindowActionRequest, timing Timing) string {
+	w, errResp := findWindow(req.WindowTarget)
+	if errResp != "" {
+		return errResp
+	}
+	label := windowLabel(req.WindowTarget, w)
 
-		if windowTitleStr != "" {
-			allWindows = append(allWindows, windowTitleStr)
+	var done string
+	var err error
+	switch req.Action {
+	case "focus_window":
+		if !focusWindow(w, timing.FocusTimeout) {
+			return toJSON("error", fmt.Sprintf("Failed to focus window: '%s'", label), nil)
 		}
-		if strings.Contains(strings.ToLower(windowTitleStr), strings.ToLower(windowTitle)) {
-			hwnd = h
-			return 0 // Stop enumeration on first match
+		noteActivated(w.Handle)
+		done = "Focused"
+	case "minimize_window":
+		err, done = backend.Windows.Minimize(w.Handle), "Minimized"
+	case "maximize_window":
+		err, done = backend.Windows.Maximize(w.Handle), "Maximized"
+	case "restore_window":
+		err, done = backend.Windows.Restore(w.Handle), "Restored"
+	case "close_window":
+		err, done = backend.Windows.Close(w.Handle), "Asked to close"
+	case "move_resize_window":
+		if req.X == nil && req.Y == nil && req.Width == nil && req.Height == nil {
+			return toJSON("error", "move_resize_window needs at least one of x, y, width or height", nil)
 		}
-		return 1 // Continue enumeration
-	}), 0)
-
-	if hwnd == 0 {
-		errorData := map[string]interface{}{
-			"searched_for":      windowTitle,
-			"available_windows": allWindows,
+		r := w.Rect
+		if req.X != nil {
+			r.Right += *req.X - r.Left
+			r.Left = *req.X
+		}
+		if req.Y != nil {
+			r.Bottom += *req.Y - r.Top
+			r.Top = *req.Y
+		}
+		if req.Width != nil {
+			r.Right = r.Left + *req.Width
+		}
+		if req.Height != nil {
+			r.Bottom = r.Top + *req.Height
+		}
+		if r.Right <= r.Left || r.Bottom <= r.Top {
+			return toJSON("error", "width and height must be positive", nil)
 		}
-		log.Printf("Window not found: '%s'. Available windows: %v\n", windowTitle, allWindows)
-		return toJSON("error", fmt.Sprintf("Window not found: '%s'. Use 'list_windows' action to see available windows.", windowTitle), errorData)
+		err, done = backend.Windows.Move(w.Handle, r), "Moved"
+	}
+	if err != nil {
+		return toJSON("error", fmt.Sprintf("Failed to %s window '%s': %v", strings.TrimSuffix(req.Action, "_window"), label, err), nil)
 	}
 
-	// If the target window is minimized, restore it first so it can receive focus
-	const SW_RESTORE = 9
-	showWindowProc.Call(uintptr(hwnd), uintptr(SW_RESTORE))
+	log.Printf("%s window '%s'\n", done, label)
+	if now, ok := windowByHandle(w.Handle); ok {
+		w = now // report its new state; a closed window is reported as it was
+	}
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("%s window '%s'", done, label),
+		"window":  w,
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
 
-	// Try a little harder to get focus reliably
-	for i := 0; i < 5; i++ {
-		setForegroundWindowProc.Call(uintptr(hwnd))
-		if waitForForeground(hwnd, 350*time.Millisecond) {
-			break
+// handleReleaseAll sends a key-up for every modifier, by virtual-key code
+// and by scan code where the backend supports it, to free keys left stuck
+// down. It needs no window.
+func handleReleaseAll() string {
+	scan, hasScan := backend.Keys.(ScanCodeInjector)
+	var released []string
+	var failed []string
+	seen := map[byte]bool{}
+	for _, name := range getAllowedKeys()["modifiers"] {
+		ks, _ := lookupKey(name)
+		if seen[ks.VK] {
+			continue
 		}
-		time.Sleep(75 * time.Millisecond)
+		seen[ks.VK] = true
+		err := backend.Keys.KeyUp(ks.VK)
+		if err == nil && hasScan {
+			err = scan.ScanUp(ks.Scan)
+		}
+		if err != nil {
+			failed = append(failed, name+": "+err.Error())
+			continue
+		}
+		released = append(released, name)
 	}
+	log.Printf("release_all: released %v\n", released)
 
-	if !waitForForeground(hwnd, 100*time.Millisecond) {
-		// Not fatal, but very useful to log
-		log.Printf("Warning: target window did not become foreground: '%s'\n", windowTitle)
-		return toJSON("error", fmt.Sprintf("Failed to focus window: '%s'", windowTitle), nil)
+	if len(failed) > 0 {
+		return toJSON("error", "Failed to release some keys", map[string]interface{}{"released": released, "failed": failed})
 	}
+	response := map[string]interface{}{
+		"status":   "success",
+		"message":  "Released all modifier keys",
+		"released": released,
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
 
-	var pressedKeys []string
-	var heldModifiers []byte // Track held modifier keys
-
-	for _, key := range keys {
-		var vkCode byte
-		switch strings.ToLower(key) {
-		// Alphabet
-		case "a":
-			vkCode = 0x41
-		case "b":
-			vkCode = 0x42
-		case "c":
-			vkCode = 0x43
-		case "d":
-			vkCode = 0x44
-		case "e":
-			vkCode = 0x45
-		case "f":
-			vkCode = 0x46
-		case "g":
-			vkCode = 0x47
-		case "h":
-			vkCode = 0x48
-		case "i":
-			vkCode = 0x49
-		case "j":
-			vkCode = 0x4A
-		case "k":
-			vkCode = 0x4B
-		case "l":
-			vkCode = 0x4C
-		case "m":
-			vkCode = 0x4D
-		case "n":
-			vkCode = 0x4E
-		case "o":
-			vkCode = 0x4F
-		case "p":
-			vkCode = 0x50
-		case "q":
-			vkCode = 0x51
-		case "r":
-			vkCode = 0x52
-		case "s":
-			vkCode = 0x53
-		case "t":
-			vkCode = 0x54
-		case "u":
-			vkCode = 0x55
-		case "v":
-			vkCode = 0x56
-		case "w":
-			vkCode = 0x57
-		case "x":
-			vkCode = 0x58
-		case "y":
-			vkCode = 0x59
-		case "z":
-			vkCode = 0x5A
-
-		// Numbers
-		case "0":
-			vkCode = 0x30
-		case "1":
-			vkCode = 0x31
-		case "2":
-			vkCode = 0x32
-		case "3":
-			vkCode = 0x33
-		case "4":
-			vkCode = 0x34
-		case "5":
-			vkCode = 0x35
-		case "6":
-			vkCode = 0x36
-		case "7":
-			vkCode = 0x37
-		case "8":
-			vkCode = 0x38
-		case "9":
-			vkCode = 0x39
-
-		// Function keys
-		case "f1":
-			vkCode = 0x70
-		case "f2":
-			vkCode = 0x71
-		case "f3":
-			vkCode = 0x72
-		case "f4":
-			vkCode = 0x73
-		case "f5":
-			vkCode = 0x74
-		case "f6":
-			vkCode = 0x75
-		case "f7":
-			vkCode = 0x76
-		case "f8":
-			vkCode = 0x77
-		case "f9":
-			vkCode = 0x78
-		case "f10":
-			vkCode = 0x79
-		case "f11":
-			vkCode = 0x7A
-		case "f12":
-			vkCode = 0x7B
-
-		// Control keys
-		case "enter", "return":
-			vkCode = 0x0D
-		case "tab":
-			vkCode = 0x09
-		case "backspace":
-			vkCode = 0x08
-		case "space":
-			vkCode = 0x20
-		case "escape":
-			vkCode = 0x1B
-		case "delete":
-			vkCode = 0x2E
-		case "insert":
-			vkCode = 0x2D
-		case "home":
-			vkCode = 0x24
-		case "end":
-			vkCode = 0x23
-		case "pageup":
-			vkCode = 0x21
-		case "pagedown":
-			vkCode = 0x22
-
-		// Arrow keys
-		case "left":
-			vkCode = 0x25
-		case "up":
-			vkCode = 0x26
-		case "right":
-			vkCode = 0x27
-		case "down":
-			vkCode = 0x28
-
-		// Special characters
-		case "!":
-			vkCode = 0x31 // Shift+1
-		case "@":
-			vkCode = 0x32 // Shift+2
-		case "#":
-			vkCode = 0x33 // Shift+3
-		case "$":
-			vkCode = 0x34 // Shift+4
-		case "%":
-			vkCode = 0x35 // Shift+5
-		case "^":
-			vkCode = 0x36 // Shift+6
-		case "&":
-			vkCode = 0x37 // Shift+7
-		case "*":
-			vkCode = 0x38 // Shift+8
-		case "(":
-			vkCode = 0x39 // Shift+9
-		case ")":
-			vkCode = 0x30 // Shift+0
-		case "-":
-			vkCode = 0xBD
-		case "_":
-			vkCode = 0xBD // Shift+-
-		case "=":
-			vkCode = 0xBB
-		case "+":
-			vkCode = 0xBB // Shift+=
-		case "[":
-			vkCode = 0xDB
-		case "{":
-			vkCode = 0xDB // Shift+[
-		case "]":
-			vkCode = 0xDD
-		case "}":
-			vkCode = 0xDD // Shift+]
-		case ";":
-			vkCode = 0xBA
-		case ":":
-			vkCode = 0xBA // Shift+;
-		case "'":
-			vkCode = 0xDE
-		case "\"":
-			vkCode = 0xDE // Shift+'
-		case ",":
-			vkCode = 0xBC
-		case "<":
-			vkCode = 0xBC // Shift+,
-		case ".":
-			vkCode = 0xBE
-		case ">":
-			vkCode = 0xBE // Shift+.
-		case "/":
-			vkCode = 0xBF
-		case "?":
-			vkCode = 0xBF // Shift+/
-		case "`":
-			vkCode = 0xC0
-		case "~":
-			vkCode = 0xC0 // Shift+`
-
-		// Modifier keys
-		case "shift":
-			vkCode = 0x10
-		case "ctrl", "control":
-			vkCode = 0x11
-		case "alt":
-			vkCode = 0x12
-		case "capslock", "caps":
-			vkCode = 0x14
-		case "numlock":
-			vkCode = 0x90
-		case "scroll":
-			vkCode = 0x91
-		case "printscreen":
-			vkCode = 0x2C
-		case "pause":
-			vkCode = 0x13
-		case "menu":
-			vkCode = 0x5D
-		case "super", "win":
-			vkCode = 0x5B
-
-		// Numpad
-		case "numpad0":
-			vkCode = 0x60
-		case "numpad1":
-			vkCode = 0x61
-		case "numpad2":
-			vkCode = 0x62
-		case "numpad3":
-			vkCode = 0x63
-		case "numpad4":
-			vkCode = 0x64
-		case "numpad5":
-			vkCode = 0x65
-		case "numpad6":
-			vkCode = 0x66
-		case "numpad7":
-			vkCode = 0x67
-		case "numpad8":
-			vkCode = 0x68
-		case "numpad9":
-			vkCode = 0x69
-		case "numpad*":
-			vkCode = 0x6A
-		case "numpad+":
-			vkCode = 0x6B
-		case "numpad-":
-			vkCode = 0x6D
-		case "numpad.":
-			vkCode = 0x6E
-		case "numpad/":
-			vkCode = 0x6F
+// SkippedChar is a character type_text could not type, by its position
+// (in characters) in the text.
+type SkippedChar struct {
+	Position int    `json:"position"`
+	Char     string `json:"char"`
+	Reason   string `json:"reason"`
+}
 
-		default:
-			return toJSON("error", "Unknown key: "+key, nil)
-		}
+func handleTypeText(target WindowTarget, text string, mode string, timing Timing) (resp string) {
+	sender, err := newKeySender(mode, timing)
+	if err != nil {
+		return toJSON("error", err.Error(), nil)
+	}
+	w, errResp := focusTarget(target, timing.FocusTimeout)
+	if errResp != "" {
+		return errResp
+	}
 
-		// Check if this is a modifier key
-		if isModifierKey(strings.ToLower(key)) {
-			// Press modifier and add to held list
-			procKeybd_event.Call(uintptr(vkCode), 0, 0, 0)
-			heldModifiers = append(heldModifiers, vkCode)
-			time.Sleep(50 * time.Millisecond)
-		} else {
-			// Regular key: press and release
-			procKeybd_event.Call(uintptr(vkCode), 0, 0, 0)
-			time.Sleep(50 * time.Millisecond)
-			procKeybd_event.Call(uintptr(vkCode), 0, 0x0002, 0)
-			time.Sleep(50 * time.Millisecond)
-		}
+	defer sender.guard(&resp)
 
-		pressedKeys = append(pressedKeys, key)
-	}
+	// A CRLF is one line break, not two
+	text = strings.ReplaceAll(text, "\r\n", "\n")
 
-	// Release all held modifier keys at the end
-	for _, vkCode := range heldModifiers {
-		procKeybd_event.Call(uintptr(vkCode), 0, 0x0002, 0)
-		time.Sleep(50 * time.Millisecond)
+	typed := 0
+	skipped := []SkippedChar{}
+	unicode, canUnicode := backend.Keys.(UnicodeTyper)
+	for i, r := range []rune(text) {
+		if ks, ok := runeKeystroke(r); ok {
+			if err := sender.tap(ks, timing.KeyDown); err != nil {
+				return toJSON("error", fmt.Sprintf("Failed to type %q after %d characters: %v", r, typed, err), nil)
+			}
+			typed++
+			continue
+		}
+		if !canUnicode {
+			skipped = append(skipped, SkippedChar{i, string(r), "no key for this character"})
+			continue
+		}
+		if err := unicode.TypeRune(r); err != nil {
+			skipped = append(skipped, SkippedChar{i, string(r), err.Error()})
+			continue
+		}
+		sender.gap()
+		typed++
 	}
 
+	if len(skipped) > 0 {
+		log.Printf("type_text skipped %d characters in '%s': %v\n", len(skipped), w.Title, skipped)
+	}
 	response := map[string]interface{}{
 		"status":  "success",
-		"message": fmt.Sprintf("Pressed keys %v in window '%s'", pressedKeys, windowTitle),
+		"message": fmt.Sprintf("Typed %d characters in window '%s'", typed, windowLabel(target, w)),
+		"typed":   typed,
+		"skipped": skipped,
 	}
 	jsonResp, _ := json.Marshal(response)
 	return string(jsonResp)
 }
 
+// windowLabel names the window a request acted on in its response: the
+// window_title it gave, or the title of the window its match found.
+func windowLabel(target WindowTarget, w Window) string {
+	if target.WindowTitle != "" {
+		return target.WindowTitle
+	}
+	return w.Title
+}
+
 func toJSON(status string, message string, data interface{}) string {
 	response := map[string]interface{}{
 		"status":  status,
@@ -706,4 +775,4 @@ func handleConnection(conn net.Conn) {
 		log.Printf("Client error: %s\n", err)
 	}
 	log.Printf("Client disconnected: %s\n", conn.RemoteAddr())
-}
\ No newline at end of file
+}
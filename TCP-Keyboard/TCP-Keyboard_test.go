@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// fast keeps the tests quick: keys are held 1 ms with no gap.
+const fast = `"key_down_ms":1,"key_gap_ms":0`
+
+// useFake installs a fresh FakeBackend for the length of the test.
+func useFake(t *testing.T) *FakeBackend {
+	t.Helper()
+	f := NewFakeBackend()
+	saved := backend
+	backend = f.Backend()
+	t.Cleanup(func() { backend = saved })
+	return f
+}
+
+// call sends message through parseMessage and decodes the response.
+func call(t *testing.T, message string) map[string]interface{} {
+	t.Helper()
+	resp := parseMessage(message)
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(resp), &m); err != nil {
+		t.Fatalf("%s: response is not JSON: %s", message, resp)
+	}
+	return m
+}
+
+func wantStatus(t *testing.T, resp map[string]interface{}, status string) {
+	t.Helper()
+	if resp["status"] != status {
+		t.Fatalf("status %v, want %s: %v", resp["status"], status, resp)
+	}
+}
+
+func down(vk byte) KeyEvent { return KeyEvent{VK: vk, Down: true} }
+
+func up(vk byte) KeyEvent { return KeyEvent{VK: vk} }
+
+func TestKeypress(t *testing.T) {
+	f := useFake(t)
+	h := f.AddWindow("Untitled - Notepad", true)
+
+	resp := call(t, `{"action":"keypress","window_title":"notepad","keys":["a","ctrl+c"],`+fast+`}`)
+	wantStatus(t, resp, "success")
+	if got := f.Foreground(); got != h {
+		t.Errorf("foreground %#x, want %#x", got, h)
+	}
+	want := []KeyEvent{down(0x41), up(0x41), down(0x11), down(0x43), up(0x43), up(0x11)}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestKeypressHeldModifier(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Game", true)
+
+	resp := call(t, `{"action":"keypress","window_title":"game","keys":["shift","a",{"key":"b"}],`+fast+`}`)
+	wantStatus(t, resp, "success")
+	want := []KeyEvent{down(0x10), down(0x41), up(0x41), down(0x42), up(0x42), up(0x10)}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestKeypressHeldModifierFor(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Game", true)
+
+	resp := call(t, `{"action":"keypress","window_title":"game","keys":[{"key":"shift","hold_ms":5},"a"],`+fast+`}`)
+	wantStatus(t, resp, "success")
+	want := []KeyEvent{down(0x10), up(0x10), down(0x41), up(0x41)}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestKeypressErrors(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Game", true)
+
+	for _, message := range []string{
+		`{"action":"keypress","window_title":"missing","keys":["a"]}`,
+		`{"action":"keypress","window_title":"game","keys":["a","nosuchkey"]}`,
+		`{"action":"keypress","window_title":"game","keys":[]}`,
+		`{"action":"keypress","keys":["a"]}`,
+	} {
+		wantStatus(t, call(t, message), "error")
+	}
+	if got := f.Events(); len(got) != 0 {
+		t.Errorf("failed requests sent %v", got)
+	}
+
+	// A bad key is reported before the request joins the input queue
+	resp := call(t, `{"action":"keypress","window_title":"game","keys":["a","nosuchkey"]}`)
+	if _, queued := resp["queue"]; queued {
+		t.Errorf("bad key was queued: %v", resp)
+	}
+}
+
+func TestListWindows(t *testing.T) {
+	f := useFake(t)
+	f.AddWindowInfo(Window{Title: "Hidden", Exe: "svc.exe"})
+	f.AddWindowInfo(Window{Title: "Sim", Exe: "sim.exe", Visible: true})
+
+	resp := call(t, `{"action":"list_windows"}`)
+	wantStatus(t, resp, "success")
+	windows := resp["windows"].([]interface{})
+	if len(windows) != 2 {
+		t.Fatalf("got %d windows, want 2", len(windows))
+	}
+	first := windows[0].(map[string]interface{})
+	if first["title"] != "Sim" || first["exe"] != "sim.exe" || first["visible"] != true {
+		t.Errorf("front window %v", first)
+	}
+
+	resp = call(t, `{"action":"list_visible_windows","titles_only":true}`)
+	if got := resp["windows"]; !reflect.DeepEqual(got, []interface{}{"Sim"}) {
+		t.Errorf("visible titles %v, want [Sim]", got)
+	}
+	resp = call(t, `{"action":"list_windows","process":"SVC"}`)
+	if got := len(resp["windows"].([]interface{})); got != 1 {
+		t.Errorf("process filter gave %d windows, want 1", got)
+	}
+}
+
+func TestTypeText(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Chat", true)
+
+	resp := call(t, `{"action":"type_text","window_title":"chat","text":"Hi!\n",`+fast+`}`)
+	wantStatus(t, resp, "success")
+	if resp["typed"] != 4.0 {
+		t.Errorf("typed %v, want 4", resp["typed"])
+	}
+	want := []KeyEvent{
+		down(0x10), down(0x48), up(0x48), up(0x10), // H
+		down(0x49), up(0x49), // i
+		down(0x10), down(0x31), up(0x31), up(0x10), // !
+		down(0x0D), up(0x0D), // enter
+	}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestTypeTextUnicode(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Chat", true)
+
+	resp := call(t, `{"action":"type_text","window_title":"chat","text":"é",`+fast+`}`)
+	wantStatus(t, resp, "success")
+	want := []KeyEvent{{Rune: 'é', Down: true}, {Rune: 'é'}}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+}
+
+func TestFocusKeepsMaximized(t *testing.T) {
+	f := useFake(t)
+	h := f.AddWindow("Sim", true)
+	f.AddWindow("Other", true)
+
+	wantStatus(t, call(t, `{"action":"maximize_window","window_title":"sim"}`), "success")
+	wantStatus(t, call(t, `{"action":"keypress","window_title":"sim","keys":["a"],`+fast+`}`), "success")
+	if w, _ := windowByHandle(h); !w.Maximized {
+		t.Error("focusing for a keypress un-maximized the window")
+	}
+
+	f.Minimize(h)
+	wantStatus(t, call(t, `{"action":"focus_window","window_title":"sim"}`), "success")
+	if w, _ := windowByHandle(h); w.Minimized {
+		t.Error("focus_window left the window minimized")
+	}
+}
+
+func TestMostRecentlyActive(t *testing.T) {
+	f := useFake(t)
+	a := f.AddWindow("Sim A", true)
+	b := f.AddWindow("Sim B", true)
+	pick := `{"action":"keypress","match":{"title_prefix":"Sim","ambiguity":"most_recently_active"},"keys":["a"],` + fast + `}`
+
+	// The server focuses B, then the user clicks A: A was active last
+	wantStatus(t, call(t, `{"action":"focus_window","window_title":"Sim B"}`), "success")
+	f.SetForeground(a)
+	wantStatus(t, call(t, pick), "success")
+	if got := f.Foreground(); got != a {
+		t.Errorf("picked %#x, want the window the user activated %#x", got, a)
+	}
+
+	// With both minimized, the one this server focused last wins
+	wantStatus(t, call(t, `{"action":"focus_window","window_title":"Sim B"}`), "success")
+	f.Minimize(a)
+	f.Minimize(b)
+	f.SetForeground(a)
+	wantStatus(t, call(t, pick), "success")
+	if got := f.Foreground(); got != b {
+		t.Errorf("picked %#x among minimized windows, want %#x", got, b)
+	}
+
+	// Closed windows are forgotten
+	wantStatus(t, call(t, `{"action":"close_window","window_title":"Sim B"}`), "success")
+	call(t, `{"action":"focus_window","window_title":"Sim A"}`)
+	activatedMu.Lock()
+	_, kept := activated[b]
+	activatedMu.Unlock()
+	if kept {
+		t.Error("activation record of a closed window was kept")
+	}
+}
+
+func TestMouseDragFarApart(t *testing.T) {
+	f := useFake(t)
+
+	resp := call(t, `{"action":"mouse_drag","x":-2000000000,"y":0,"to_x":2000000000,"to_y":-2000000000,`+fast+`}`)
+	wantStatus(t, resp, "success")
+	var moves []MouseEvent
+	for _, e := range f.MouseEvents() {
+		if e.Kind == "move" {
+			moves = append(moves, e)
+		}
+	}
+	if len(moves) != dragSteps+1 {
+		t.Fatalf("%d moves, want %d", len(moves), dragSteps+1)
+	}
+	for i := 1; i < len(moves); i++ {
+		if moves[i].X <= moves[i-1].X || moves[i].Y >= moves[i-1].Y {
+			t.Errorf("move %d went from %v to %v, against the drag", i, moves[i-1], moves[i])
+		}
+	}
+	if last := moves[len(moves)-1]; last.X != 2000000000 || last.Y != -2000000000 {
+		t.Errorf("drag ended at (%d, %d)", last.X, last.Y)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits for launch_app and terminate_process waits.
+const (
+	defaultLaunchWait = 30 * time.Second
+	maxLaunchWait     = 5 * time.Minute
+	maxGracefulWait   = time.Minute
+
+	// Exited launches are reported for a while, up to a number of them
+	exitedRetention = time.Hour
+	maxExited       = 100
+)
+
+// Process is a running process as reported by list_processes. App is the
+// configured app id its executable belongs to, if any.
+type Process struct {
+	PID      uint32     `json:"pid"`
+	Exe      string     `json:"exe"`
+	App      string     `json:"app,omitempty"`
+	Launched bool       `json:"launched"` // started by this server
+	Started  *time.Time `json:"started,omitempty"`
+	Exited   bool       `json:"exited,omitempty"`
+	ExitCode int        `json:"exit_code,omitempty"`
+}
+
+// launchedProc is a process started by launch_app, kept for a while after it
+// exits so list_processes can report how it ended.
+type launchedProc struct {
+	app      string
+	exe      string
+	started  time.Time
+	created  time.Time // as the OS reports it, zero if unknown
+	exited   bool
+	exitedAt time.Time
+	exitCode int
+}
+
+// owns reports whether the running process with pid is still the one that
+// was launched: pids are reused, so the start time must match too.
+func (l *launchedProc) owns(pid uint32) bool {
+	if l.exited {
+		return false
+	}
+	if l.created.IsZero() {
+		return true
+	}
+	created, ok := processStartTime(pid)
+	return ok && created.Equal(l.created)
+}
+
+// pruneLaunched forgets exited launches after exitedRetention, and the
+// oldest beyond maxExited. launchedMu must be held.
+func pruneLaunched() {
+	var exited []uint32
+	for pid, l := range launched {
+		switch {
+		case !l.exited:
+		case time.Since(l.exitedAt) > exitedRetention:
+			delete(launched, pid)
+		default:
+			exited = append(exited, pid)
+		}
+	}
+	if len(exited) <= maxExited {
+		return
+	}
+	sort.Slice(exited, func(i, j int) bool { return launched[exited[i]].exitedAt.Before(launched[exited[j]].exitedAt) })
+	for _, pid := range exited[:len(exited)-maxExited] {
+		delete(launched, pid)
+	}
+}
+
+var (
+	launchedMu sync.Mutex
+	launched   = map[uint32]*launchedProc{}
+)
+
+type LaunchAppRequest struct {
+	Action        string   `json:"action"`
+	App           string   `json:"app"`
+	Args          []string `json:"args"`
+	WorkingDir    string   `json:"working_dir"`
+	WaitForTitle  string   `json:"wait_for_title"` // regex; wait for a new window whose title matches
+	WaitTimeoutMs int      `json:"wait_timeout_ms"`
+}
+
+type ListProcessesRequest struct {
+	Action string `json:"action"`
+	App    string `json:"app"`
+}
+
+type TerminateProcessRequest struct {
+	Action     string `json:"action"`
+	PID        uint32 `json:"pid"`
+	GracefulMs int    `json:"graceful_ms"` // ask its windows to close and wait this long first
+}
+
+// appForProcess returns the id of the configured app p is running. A
+// matching file name is not enough: the process must run the configured
+// path, so another program of the same name is never taken for the app.
+func appForProcess(p Process) (string, bool) {
+	path := ""
+	for id, app := range config.Apps {
+		if !sameExe(p.Exe, filepath.Base(app.Path)) {
+			continue
+		}
+		if path == "" {
+			if path = processPath(p.PID); path == "" {
+				return "", false
+			}
+		}
+		if samePath(path, app.Path) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// allowsDir reports whether dir is one of the app's working_dirs.
+func (a AppConfig) allowsDir(dir string) bool {
+	if !filepath.IsAbs(dir) {
+		return false
+	}
+	for _, d := range a.WorkingDirs {
+		if samePath(d, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleLaunchApp(req LaunchAppRequest) string {
+	app, ok := config.Apps[req.App]
+	if !ok {
+		return toJSON("error", fmt.Sprintf("Unknown app '%s'. Apps must be listed in the config file.", req.App), nil)
+	}
+	if len(req.Args) > 0 && !app.AllowExtraArgs {
+		return toJSON("error", fmt.Sprintf("App '%s' does not allow extra args", req.App), nil)
+	}
+	if req.WorkingDir != "" && !app.allowsDir(req.WorkingDir) {
+		return toJSON("error", fmt.Sprintf("App '%s' does not allow working_dir '%s'; add it to the app's working_dirs", req.App, req.WorkingDir), nil)
+	}
+	var titleRe *regexp.Regexp
+	if req.WaitForTitle != "" {
+		var err error
+		if titleRe, err = regexp.Compile(req.WaitForTitle); err != nil {
+			return toJSON("error", "Invalid wait_for_title: "+err.Error(), nil)
+		}
+	}
+	wait := defaultLaunchWait
+	if req.WaitTimeoutMs > 0 {
+		wait = time.Duration(req.WaitTimeoutMs) * time.Millisecond
+	}
+	if req.WaitTimeoutMs < 0 || wait > maxLaunchWait {
+		return toJSON("error", fmt.Sprintf("wait_timeout_ms must be between 0 and %d", maxLaunchWait.Milliseconds()), nil)
+	}
+
+	// Windows already open cannot be the new one
+	before := map[WindowHandle]bool{}
+	if titleRe != nil {
+		windows, err := backend.Windows.Windows()
+		if err != nil {
+			return toJSON("error", "Failed to list windows: "+err.Error(), nil)
+		}
+		for _, w := range windows {
+			before[w.Handle] = true
+		}
+	}
+
+	cmd := exec.Command(app.Path, append(append([]string(nil), app.Args...), req.Args...)...)
+	cmd.Dir = app.Dir
+	if req.WorkingDir != "" {
+		cmd.Dir = req.WorkingDir
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("launch_app '%s' failed: %v\n", req.App, err)
+		return toJSON("error", fmt.Sprintf("Failed to launch '%s': %v", req.App, err), nil)
+	}
+	pid := uint32(cmd.Process.Pid)
+	proc := &launchedProc{app: req.App, exe: filepath.Base(app.Path), started: time.Now()}
+	proc.created, _ = processStartTime(pid)
+	launchedMu.Lock()
+	launched[pid] = proc
+	launchedMu.Unlock()
+	go func() {
+		cmd.Wait()
+		launchedMu.Lock()
+		proc.exited, proc.exitedAt, proc.exitCode = true, time.Now(), cmd.ProcessState.ExitCode()
+		pruneLaunched()
+		launchedMu.Unlock()
+		log.Printf("App '%s' (pid %d) exited with code %d\n", proc.app, pid, proc.exitCode)
+	}()
+	log.Printf("Launched app '%s' as pid %d\n", req.App, pid)
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Launched '%s'", req.App),
+		"pid":     pid,
+	}
+	if titleRe != nil {
+		w, ok := waitForNewWindow(titleRe, pid, before, wait)
+		if !ok {
+			return toJSON("error", fmt.Sprintf("Launched '%s' but no window matching '%s' appeared within %d ms", req.App, req.WaitForTitle, wait.Milliseconds()),
+				map[string]interface{}{"pid": pid})
+		}
+		response["window_handle"] = w.Handle
+		response["window"] = w
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
+
+// waitForNewWindow polls for a window whose title matches re and that is
+// either owned by pid or was not open before the launch (launchers often
+// hand over to another process).
+func waitForNewWindow(re *regexp.Regexp, pid uint32, before map[WindowHandle]bool, timeout time.Duration) (Window, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if windows, err := backend.Windows.Windows(); err == nil {
+			for _, w := range windows {
+				if re.MatchString(w.Title) && (w.PID == pid || !before[w.Handle]) {
+					return w, true
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return Window{}, false
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// handleListProcesses lists the running processes of configured apps, and
+// every process launch_app has started, including those that have exited.
+func handleListProcesses(req ListProcessesRequest) string {
+	if req.App != "" {
+		if _, ok := config.Apps[req.App]; !ok {
+			return toJSON("error", fmt.Sprintf("Unknown app '%s'", req.App), nil)
+		}
+	}
+	running, err := listProcesses()
+	if err != nil {
+		return toJSON("error", "Failed to list processes: "+err.Error(), nil)
+	}
+
+	launchedMu.Lock()
+	defer launchedMu.Unlock()
+	pruneLaunched()
+	procs := []Process{}
+	alive := map[uint32]bool{}
+	for _, p := range running {
+		l := launched[p.PID]
+		if l != nil && l.owns(p.PID) {
+			p.App, p.Launched, p.Started = l.app, true, &l.started
+			alive[p.PID] = true
+		} else if id, ok := appForProcess(p); ok {
+			p.App = id
+		} else {
+			continue
+		}
+		if req.App == "" || p.App == req.App {
+			procs = append(procs, p)
+		}
+	}
+	for pid, l := range launched {
+		if alive[pid] || !l.exited || (req.App != "" && l.app != req.App) {
+			continue
+		}
+		started := l.started
+		procs = append(procs, Process{PID: pid, Exe: l.exe, App: l.app, Launched: true, Started: &started, Exited: true, ExitCode: l.exitCode})
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"processes": procs,
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
+
+// handleTerminateProcess ends a process of a configured app: one this server
+// launched, or one running the app's configured path. With
+// graceful_ms its windows are first asked to close, and it is only killed
+// if it is still running after that long.
+func handleTerminateProcess(req TerminateProcessRequest) string {
+	if req.PID == 0 {
+		return toJSON("error", "Missing pid field", nil)
+	}
+	if req.GracefulMs < 0 || time.Duration(req.GracefulMs)*time.Millisecond > maxGracefulWait {
+		return toJSON("error", fmt.Sprintf("graceful_ms must be between 0 and %d", maxGracefulWait.Milliseconds()), nil)
+	}
+	running, err := listProcesses()
+	if err != nil {
+		return toJSON("error", "Failed to list processes: "+err.Error(), nil)
+	}
+	var target *Process
+	for i := range running {
+		if running[i].PID == req.PID {
+			target = &running[i]
+		}
+	}
+	if target == nil {
+		return toJSON("error", fmt.Sprintf("No running process %d", req.PID), nil)
+	}
+	app, ok := appForProcess(*target)
+	launchedMu.Lock()
+	if l := launched[req.PID]; l != nil && l.owns(req.PID) {
+		app, ok = l.app, true
+	}
+	launchedMu.Unlock()
+	if !ok {
+		return toJSON("error", fmt.Sprintf("Process %d (%s) is not a configured app", req.PID, target.Exe), nil)
+	}
+
+	if req.GracefulMs > 0 {
+		if closeProcessWindows(req.PID) && waitForExit(req.PID, time.Duration(req.GracefulMs)*time.Millisecond) {
+			log.Printf("Process %d (%s) closed gracefully\n", req.PID, app)
+			return toJSON("success", fmt.Sprintf("Process %d (%s) closed", req.PID, app), nil)
+		}
+	}
+
+	p, err := os.FindProcess(int(req.PID))
+	if err == nil {
+		err = p.Kill()
+	}
+	if err != nil {
+		return toJSON("error", fmt.Sprintf("Failed to terminate process %d: %v", req.PID, err), nil)
+	}
+	log.Printf("Terminated process %d (%s)\n", req.PID, app)
+	return toJSON("success", fmt.Sprintf("Terminated process %d (%s)", req.PID, app), nil)
+}
+
+// closeProcessWindows asks every window pid owns to close, reporting
+// whether it had any.
+func closeProcessWindows(pid uint32) bool {
+	windows, err := backend.Windows.Windows()
+	if err != nil {
+		return false
+	}
+	found := false
+	for _, w := range windows {
+		if w.PID == pid && w.Visible {
+			backend.Windows.Close(w.Handle)
+			found = true
+		}
+	}
+	return found
+}
+
+// waitForExit polls until pid is no longer running or timeout passes.
+func waitForExit(pid uint32, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := listProcesses()
+		if err != nil {
+			return false
+		}
+		alive := false
+		for _, p := range running {
+			if p.PID == pid {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// WindowHandle identifies a top-level window: an HWND on Windows.
+type WindowHandle uintptr
+
+// Rect is a window's outer rectangle in screen coordinates.
+type Rect struct {
+	Left   int32 `json:"left"`
+	Top    int32 `json:"top"`
+	Right  int32 `json:"right"`
+	Bottom int32 `json:"bottom"`
+}
+
+// Window is a top-level window as reported by a WindowManager. Fields the
+// platform cannot tell are left empty.
+type Window struct {
+	Handle    WindowHandle `json:"handle"`
+	Title     string       `json:"title"`
+	Class     string       `json:"class"`
+	PID       uint32       `json:"pid"`
+	Exe       string       `json:"exe"` // executable file name, without its directory
+	Visible   bool         `json:"visible"`
+	Minimized bool         `json:"minimized"`
+	Maximized bool         `json:"maximized"`
+	Rect      Rect         `json:"rect"`
+}
+
+// WindowManager lists and focuses top-level windows.
+type WindowManager interface {
+	// Windows returns every top-level window in z-order, front-most first.
+	Windows() ([]Window, error)
+	// Foreground returns the window that currently has focus, or 0.
+	Foreground() WindowHandle
+	// SetForeground asks for h to become the foreground window.
+	SetForeground(h WindowHandle) error
+	// Restore un-minimizes h so it can receive focus.
+	Restore(h WindowHandle) error
+	// Minimize iconifies h.
+	Minimize(h WindowHandle) error
+	// Maximize makes h fill its screen.
+	Maximize(h WindowHandle) error
+	// Close asks h to close, as its close button would; the application
+	// may still refuse or ask to save first.
+	Close(h WindowHandle) error
+	// Move moves and resizes h to r, in screen coordinates.
+	Move(h WindowHandle, r Rect) error
+	// ClientRect returns h's client area, inside its frame and title bar,
+	// in screen coordinates.
+	ClientRect(h WindowHandle) (Rect, error)
+}
+
+// KeyInjector sends synthetic key events, identified by Windows virtual-key
+// code, to whichever window has focus.
+type KeyInjector interface {
+	KeyDown(vk byte) error
+	KeyUp(vk byte) error
+}
+
+// ScanCodeInjector is implemented by KeyInjectors that can also send keys as
+// hardware scan codes (see Keystroke.Scan), which games reading DirectInput
+// or raw input see where they ignore virtual-key events.
+type ScanCodeInjector interface {
+	ScanDown(scan uint16) error
+	ScanUp(scan uint16) error
+}
+
+// UnicodeTyper is implemented by KeyInjectors that can type a character
+// which has no key on the current layout.
+type UnicodeTyper interface {
+	TypeRune(r rune) error
+}
+
+// LayoutMapper is implemented by KeyInjectors that can find the key typing a
+// character on the keyboard layout in use, rather than assuming US.
+type LayoutMapper interface {
+	// RuneKeystroke returns the key that types r, with Shift set if it is
+	// the key's shifted character, or false if no key types r alone.
+	RuneKeystroke(r rune) (Keystroke, bool)
+}
+
+// MouseButton is a mouse button a MouseInjector can press.
+type MouseButton int
+
+const (
+	ButtonLeft MouseButton = iota + 1
+	ButtonRight
+	ButtonMiddle
+)
+
+// MouseInjector sends synthetic mouse events.
+type MouseInjector interface {
+	// MoveTo puts the pointer at x, y in screen coordinates.
+	MoveTo(x, y int32) error
+	ButtonDown(b MouseButton) error
+	ButtonUp(b MouseButton) error
+	// Scroll turns the wheel dy notches up (negative for down) and the
+	// horizontal wheel dx notches right.
+	Scroll(dx, dy int32) error
+}
+
+// Backend is the platform implementation the server drives.
+type Backend struct {
+	Name    string
+	Windows WindowManager
+	Keys    KeyInjector
+	Mouse   MouseInjector // nil if the platform cannot send mouse input
+}
+
+// backend is chosen at startup by newBackend.
+var backend *Backend
+
+// nativeBackends holds the constructors for the real backends built for
+// this platform, registered by the platform files.
+var nativeBackends = map[string]func() (*Backend, error){}
+
+// newBackend returns the named backend, or the platform default if name is
+// empty. "fake" is always available.
+func newBackend(name string) (*Backend, error) {
+	if name == "" {
+		name = defaultBackend
+	}
+	if name == "fake" {
+		return NewFakeBackend().Backend(), nil
+	}
+	ctor, ok := nativeBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("backend '%s' is not available on %s", name, runtime.GOOS)
+	}
+	return ctor()
+}
+
+func waitForForeground(hwnd WindowHandle, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if backend.Windows.Foreground() == hwnd {
+			return true
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	return false
+}
+
+// focusWindow brings w to the foreground, restoring it first if it is
+// minimized, and reports whether it got there within timeout. A maximized
+// window stays maximized.
+func focusWindow(w Window, timeout time.Duration) bool {
+	hwnd := w.Handle
+	deadline := time.Now().Add(timeout)
+
+	// If the target window is minimized, restore it first so it can receive
+	// focus. Restoring any other window would also un-maximize it.
+	if w.Minimized {
+		backend.Windows.Restore(hwnd)
+	}
+
+	// Try a little harder to get focus reliably
+	for {
+		backend.Windows.SetForeground(hwnd)
+		wait := time.Until(deadline)
+		if wait > 350*time.Millisecond {
+			wait = 350 * time.Millisecond
+		}
+		if waitForForeground(hwnd, wait) {
+			return true
+		}
+		if time.Until(deadline) <= 75*time.Millisecond {
+			break
+		}
+		time.Sleep(75 * time.Millisecond)
+	}
+
+	return backend.Windows.Foreground() == hwnd
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// KeyEvent is one key transition recorded by FakeBackend. Keys sent by scan
+// code have only Scan set, and characters typed through TypeRune only Rune.
+type KeyEvent struct {
+	VK   byte
+	Scan uint16
+	Rune rune
+	Down bool
+}
+
+// MouseEvent is one mouse event recorded by FakeBackend: a move to X, Y, a
+// Button going down or up, or a Scroll of DX, DY notches.
+type MouseEvent struct {
+	Kind   string // "move", "down", "up" or "scroll"
+	X, Y   int32
+	Button MouseButton
+	DX, DY int32
+}
+
+// FakeBackend simulates a desktop in memory. It records injected key events
+// and mouse events and tracks focus so handlers can be exercised without a real display.
+type FakeBackend struct {
+	mu         sync.Mutex
+	windows    []Window
+	foreground WindowHandle
+	events     []KeyEvent
+	mouse      []MouseEvent
+	nextHandle WindowHandle
+}
+
+// NewFakeBackend returns an empty simulated desktop.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{nextHandle: 1}
+}
+
+// Backend returns a Backend that drives f.
+func (f *FakeBackend) Backend() *Backend {
+	return &Backend{Name: "fake", Windows: f, Keys: f, Mouse: f}
+}
+
+// AddWindow opens a simulated window on top of the others and returns its
+// handle.
+func (f *FakeBackend) AddWindow(title string, visible bool) WindowHandle {
+	return f.AddWindowInfo(Window{Title: title, Visible: visible})
+}
+
+// AddWindowInfo opens a simulated window described by w, on top of the
+// others, and returns the handle it was given in place of w.Handle.
+func (f *FakeBackend) AddWindowInfo(w Window) WindowHandle {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Handle = f.nextHandle
+	f.nextHandle++
+	f.windows = append([]Window{w}, f.windows...)
+	return w.Handle
+}
+
+// Events returns the key events injected so far.
+func (f *FakeBackend) Events() []KeyEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]KeyEvent(nil), f.events...)
+}
+
+// MouseEvents returns the mouse events injected so far.
+func (f *FakeBackend) MouseEvents() []MouseEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]MouseEvent(nil), f.mouse...)
+}
+
+func (f *FakeBackend) Windows() ([]Window, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Window(nil), f.windows...), nil
+}
+
+func (f *FakeBackend) Foreground() WindowHandle {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.foreground
+}
+
+func (f *FakeBackend) SetForeground(h WindowHandle) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.find(h)
+	if i < 0 {
+		return fmt.Errorf("no window %#x", h)
+	}
+	// Move it to the front of the z-order
+	w := f.windows[i]
+	copy(f.windows[1:i+1], f.windows[:i])
+	f.windows[0] = w
+	f.foreground = h
+	return nil
+}
+
+func (f *FakeBackend) Restore(h WindowHandle) error {
+	return f.update(h, func(w *Window) { w.Minimized, w.Maximized = false, false })
+}
+
+func (f *FakeBackend) KeyDown(vk byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, KeyEvent{VK: vk, Down: true})
+	return nil
+}
+
+func (f *FakeBackend) KeyUp(vk byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, KeyEvent{VK: vk, Down: false})
+	return nil
+}
+
+func (f *FakeBackend) ScanDown(scan uint16) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, KeyEvent{Scan: scan, Down: true})
+	return nil
+}
+
+func (f *FakeBackend) ScanUp(scan uint16) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, KeyEvent{Scan: scan, Down: false})
+	return nil
+}
+
+func (f *FakeBackend) TypeRune(r rune) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, KeyEvent{Rune: r, Down: true}, KeyEvent{Rune: r, Down: false})
+	return nil
+}
+
+func (f *FakeBackend) Minimize(h WindowHandle) error {
+	return f.update(h, func(w *Window) { w.Minimized, w.Maximized = true, false })
+}
+
+func (f *FakeBackend) Maximize(h WindowHandle) error {
+	return f.update(h, func(w *Window) { w.Minimized, w.Maximized = false, true })
+}
+
+// Close closes the window at once; simulated applications never refuse.
+func (f *FakeBackend) Close(h WindowHandle) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.find(h)
+	if i < 0 {
+		return fmt.Errorf("no window %#x", h)
+	}
+	f.windows = append(f.windows[:i], f.windows[i+1:]...)
+	if f.foreground == h {
+		f.foreground = 0
+	}
+	return nil
+}
+
+func (f *FakeBackend) Move(h WindowHandle, r Rect) error {
+	return f.update(h, func(w *Window) { w.Rect = r })
+}
+
+// ClientRect is the whole window; simulated windows have no frame.
+func (f *FakeBackend) ClientRect(h WindowHandle) (Rect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.find(h)
+	if i < 0 {
+		return Rect{}, fmt.Errorf("no window %#x", h)
+	}
+	return f.windows[i].Rect, nil
+}
+
+func (f *FakeBackend) MoveTo(x, y int32) error {
+	return f.recordMouse(MouseEvent{Kind: "move", X: x, Y: y})
+}
+
+func (f *FakeBackend) ButtonDown(b MouseButton) error {
+	return f.recordMouse(MouseEvent{Kind: "down", Button: b})
+}
+
+func (f *FakeBackend) ButtonUp(b MouseButton) error {
+	return f.recordMouse(MouseEvent{Kind: "up", Button: b})
+}
+
+func (f *FakeBackend) Scroll(dx, dy int32) error {
+	return f.recordMouse(MouseEvent{Kind: "scroll", DX: dx, DY: dy})
+}
+
+func (f *FakeBackend) recordMouse(e MouseEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mouse = append(f.mouse, e)
+	return nil
+}
+
+// update applies change to window h.
+func (f *FakeBackend) update(h WindowHandle, change func(*Window)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.find(h)
+	if i < 0 {
+		return fmt.Errorf("no window %#x", h)
+	}
+	change(&f.windows[i])
+	return nil
+}
+
+// find returns the index of h in f.windows, or -1. f.mu must be held.
+func (f *FakeBackend) find(h WindowHandle) int {
+	for i, w := range f.windows {
+		if w.Handle == h {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,402 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	user32                       = syscall.NewLazyDLL("user32.dll")
+	enumWindowsProc              = user32.NewProc("EnumWindows")
+	getWindowTextWProc           = user32.NewProc("GetWindowTextW")
+	getWindowTextLengthWProc     = user32.NewProc("GetWindowTextLengthW")
+	getClassNameWProc            = user32.NewProc("GetClassNameW")
+	getWindowThreadProcessIdProc = user32.NewProc("GetWindowThreadProcessId")
+	getWindowRectProc            = user32.NewProc("GetWindowRect")
+	getClientRectProc            = user32.NewProc("GetClientRect")
+	clientToScreenProc           = user32.NewProc("ClientToScreen")
+	getSystemMetricsProc         = user32.NewProc("GetSystemMetrics")
+	isIconicProc                 = user32.NewProc("IsIconic")
+	isZoomedProc                 = user32.NewProc("IsZoomed")
+	setForegroundWindowProc      = user32.NewProc("SetForegroundWindow")
+	getForegroundWindowProc      = user32.NewProc("GetForegroundWindow")
+	showWindowProc               = user32.NewProc("ShowWindow")
+	moveWindowProc               = user32.NewProc("MoveWindow")
+	postMessageWProc             = user32.NewProc("PostMessageW")
+	isWindowVisibleProc          = user32.NewProc("IsWindowVisible")
+	sendInputProc                = user32.NewProc("SendInput")
+	getKeyboardLayoutProc        = user32.NewProc("GetKeyboardLayout")
+	vkKeyScanExWProc             = user32.NewProc("VkKeyScanExW")
+	mapVirtualKeyExWProc         = user32.NewProc("MapVirtualKeyExW")
+
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	queryFullProcessImageNameWProc = kernel32.NewProc("QueryFullProcessImageNameW")
+)
+
+const (
+	SW_MAXIMIZE                       = 3
+	SW_MINIMIZE                       = 6
+	SW_RESTORE                        = 9
+	WM_CLOSE                          = 0x0010
+	PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+	KEYEVENTF_EXTENDEDKEY             = 0x0001
+	KEYEVENTF_KEYUP                   = 0x0002
+	KEYEVENTF_UNICODE                 = 0x0004
+	KEYEVENTF_SCANCODE                = 0x0008
+	MAPVK_VK_TO_VSC_EX                = 4
+	INPUT_MOUSE                       = 0
+	INPUT_KEYBOARD                    = 1
+	MOUSEEVENTF_MOVE                  = 0x0001
+	MOUSEEVENTF_LEFTDOWN              = 0x0002
+	MOUSEEVENTF_LEFTUP                = 0x0004
+	MOUSEEVENTF_RIGHTDOWN             = 0x0008
+	MOUSEEVENTF_RIGHTUP               = 0x0010
+	MOUSEEVENTF_MIDDLEDOWN            = 0x0020
+	MOUSEEVENTF_MIDDLEUP              = 0x0040
+	MOUSEEVENTF_WHEEL                 = 0x0800
+	MOUSEEVENTF_HWHEEL                = 0x1000
+	MOUSEEVENTF_VIRTUALDESK           = 0x4000
+	MOUSEEVENTF_ABSOLUTE              = 0x8000
+	WHEEL_DELTA                       = 120
+	SM_XVIRTUALSCREEN                 = 76
+	SM_YVIRTUALSCREEN                 = 77
+	SM_CXVIRTUALSCREEN                = 78
+	SM_CYVIRTUALSCREEN                = 79
+)
+
+// KEYBDINPUT and INPUT mirror the Win32 structures for keyboard input. The
+// padding makes INPUT as large as its biggest union member, MOUSEINPUT.
+type KEYBDINPUT struct {
+	WVk         uint16
+	WScan       uint16
+	DwFlags     uint32
+	Time        uint32
+	DwExtraInfo uintptr
+}
+
+type INPUT struct {
+	Type uint32
+	Ki   KEYBDINPUT
+	_    [8]byte
+}
+
+// MOUSEINPUT mirrors the Win32 structure, and mouseINPUT is INPUT seen
+// through its MOUSEINPUT member; both INPUT forms are the same size.
+type MOUSEINPUT struct {
+	Dx          int32
+	Dy          int32
+	MouseData   uint32
+	DwFlags     uint32
+	Time        uint32
+	DwExtraInfo uintptr
+}
+
+type mouseINPUT struct {
+	Type uint32
+	Mi   MOUSEINPUT
+}
+
+const defaultBackend = "win32"
+
+func init() {
+	nativeBackends["win32"] = func() (*Backend, error) {
+		return &Backend{Name: "win32", Windows: win32Windows{}, Keys: win32Keys{}, Mouse: win32Mouse{}}, nil
+	}
+}
+
+// win32Windows is the WindowManager for the Windows desktop.
+type win32Windows struct{}
+
+// enumWindowsCallback collects the handles EnumWindows passes it into
+// enumHandles. It is created once: Go allows only a limited number of
+// callbacks per process and never frees them, and Windows is polled.
+var (
+	enumMu              sync.Mutex
+	enumHandles         []syscall.Handle
+	enumWindowsCallback = syscall.NewCallback(func(h syscall.Handle, lparam uintptr) uintptr {
+		enumHandles = append(enumHandles, h)
+		return 1 // Continue enumeration
+	})
+)
+
+// topLevelWindows returns the handles of all top-level windows in z-order.
+func topLevelWindows() []syscall.Handle {
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	enumHandles = nil
+	enumWindowsProc.Call(enumWindowsCallback, 0)
+	handles := enumHandles
+	enumHandles = nil
+	return handles
+}
+
+func (win32Windows) Windows() ([]Window, error) {
+	var windows []Window
+	exes := map[uint32]string{} // by pid, as most processes own several windows
+	for _, h := range topLevelWindows() {
+		w := Window{
+			Handle: WindowHandle(h),
+			Title:  windowText(h),
+			Class:  className(h),
+		}
+		getWindowThreadProcessIdProc.Call(uintptr(h), uintptr(unsafe.Pointer(&w.PID)))
+		exe, ok := exes[w.PID]
+		if !ok {
+			exe = processExe(w.PID)
+			exes[w.PID] = exe
+		}
+		w.Exe = exe
+		visible, _, _ := isWindowVisibleProc.Call(uintptr(h))
+		iconic, _, _ := isIconicProc.Call(uintptr(h))
+		zoomed, _, _ := isZoomedProc.Call(uintptr(h))
+		w.Visible, w.Minimized, w.Maximized = visible != 0, iconic != 0, zoomed != 0
+		getWindowRectProc.Call(uintptr(h), uintptr(unsafe.Pointer(&w.Rect)))
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// windowText returns a window's whole title, however long.
+func windowText(h syscall.Handle) string {
+	n, _, _ := getWindowTextLengthWProc.Call(uintptr(h))
+	if n == 0 {
+		return ""
+	}
+	buf := make([]uint16, n+1)
+	n, _, _ = getWindowTextWProc.Call(uintptr(h), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return syscall.UTF16ToString(buf[:n])
+}
+
+func className(h syscall.Handle) string {
+	var buf [256]uint16 // class names are limited to 256 characters
+	n, _, _ := getClassNameWProc.Call(uintptr(h), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return syscall.UTF16ToString(buf[:n])
+}
+
+// processExe returns the file name of the executable running as pid, or ""
+// if it cannot be queried (protected and system processes).
+func processExe(pid uint32) string {
+	if path := processPath(pid); path != "" {
+		return filepath.Base(path)
+	}
+	return ""
+}
+
+// processPath returns the full path of the executable running as pid, or
+// "" if it cannot be queried.
+func processPath(pid uint32) string {
+	p, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return ""
+	}
+	defer syscall.CloseHandle(p)
+	var buf [syscall.MAX_LONG_PATH]uint16
+	n := uint32(len(buf))
+	ok, _, _ := queryFullProcessImageNameWProc.Call(uintptr(p), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n)))
+	if ok == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf[:n])
+}
+
+func (win32Windows) Foreground() WindowHandle {
+	fg, _, _ := getForegroundWindowProc.Call()
+	return WindowHandle(fg)
+}
+
+func (win32Windows) SetForeground(h WindowHandle) error {
+	setForegroundWindowProc.Call(uintptr(h))
+	return nil
+}
+
+func (win32Windows) Restore(h WindowHandle) error {
+	showWindowProc.Call(uintptr(h), uintptr(SW_RESTORE))
+	return nil
+}
+
+func (win32Windows) Minimize(h WindowHandle) error {
+	showWindowProc.Call(uintptr(h), uintptr(SW_MINIMIZE))
+	return nil
+}
+
+func (win32Windows) Maximize(h WindowHandle) error {
+	showWindowProc.Call(uintptr(h), uintptr(SW_MAXIMIZE))
+	return nil
+}
+
+func (win32Windows) Close(h WindowHandle) error {
+	ok, _, err := postMessageWProc.Call(uintptr(h), WM_CLOSE, 0, 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func (win32Windows) Move(h WindowHandle, r Rect) error {
+	ok, _, err := moveWindowProc.Call(uintptr(h), uintptr(r.Left), uintptr(r.Top),
+		uintptr(r.Right-r.Left), uintptr(r.Bottom-r.Top), 1) // repaint
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func (win32Windows) ClientRect(h WindowHandle) (Rect, error) {
+	var r Rect
+	ok, _, err := getClientRectProc.Call(uintptr(h), uintptr(unsafe.Pointer(&r)))
+	if ok == 0 {
+		return Rect{}, err
+	}
+	// The client rect starts at 0, 0; its corner gives the offset
+	origin := struct{ X, Y int32 }{}
+	ok, _, err = clientToScreenProc.Call(uintptr(h), uintptr(unsafe.Pointer(&origin)))
+	if ok == 0 {
+		return Rect{}, err
+	}
+	return Rect{Left: origin.X, Top: origin.Y, Right: origin.X + r.Right, Bottom: origin.Y + r.Bottom}, nil
+}
+
+// win32Keys injects keys with SendInput.
+type win32Keys struct{}
+
+// extendedVKs are the virtual keys that need KEYEVENTF_EXTENDEDKEY to be
+// told apart from their numpad twins.
+var extendedVKs = map[byte]bool{
+	0x21: true, 0x22: true, 0x23: true, 0x24: true, // PageUp, PageDown, End, Home
+	0x25: true, 0x26: true, 0x27: true, 0x28: true, // arrows
+	0x2C: true, 0x2D: true, 0x2E: true, // PrintScreen, Insert, Delete
+	0x5B: true, 0x5D: true, // Win, Menu
+	0x6F: true, 0x90: true, // numpad /, NumLock
+}
+
+func (win32Keys) KeyDown(vk byte) error { return sendVK(vk, 0) }
+
+func (win32Keys) KeyUp(vk byte) error { return sendVK(vk, KEYEVENTF_KEYUP) }
+
+func sendVK(vk byte, flags uint32) error {
+	if extendedVKs[vk] {
+		flags |= KEYEVENTF_EXTENDEDKEY
+	}
+	return sendInput(INPUT{Type: INPUT_KEYBOARD, Ki: KEYBDINPUT{WVk: uint16(vk), DwFlags: flags}})
+}
+
+func (win32Keys) ScanDown(scan uint16) error { return sendScan(scan, 0) }
+
+func (win32Keys) ScanUp(scan uint16) error { return sendScan(scan, KEYEVENTF_KEYUP) }
+
+func sendScan(scan uint16, flags uint32) error {
+	flags |= KEYEVENTF_SCANCODE
+	if scan>>8 == 0xE0 {
+		flags |= KEYEVENTF_EXTENDEDKEY
+	}
+	return sendInput(INPUT{Type: INPUT_KEYBOARD, Ki: KEYBDINPUT{WScan: scan & 0xFF, DwFlags: flags}})
+}
+
+// RuneKeystroke looks r up in the keyboard layout of the foreground window's
+// thread, which is the layout the window will read the key with. Characters
+// that need Ctrl or Alt (AltGr) as well are left to TypeRune.
+func (win32Keys) RuneKeystroke(r rune) (Keystroke, bool) {
+	if r > 0xFFFF {
+		return Keystroke{}, false
+	}
+	fg, _, _ := getForegroundWindowProc.Call()
+	thread, _, _ := getWindowThreadProcessIdProc.Call(fg, 0)
+	hkl, _, _ := getKeyboardLayoutProc.Call(thread)
+
+	ret, _, _ := vkKeyScanExWProc.Call(uintptr(r), hkl)
+	// The low byte is the virtual key, the high byte the shift state
+	key := int16(ret)
+	if key == -1 || key>>8&^1 != 0 {
+		return Keystroke{}, false
+	}
+	vk := byte(key)
+	scan, _, _ := mapVirtualKeyExWProc.Call(uintptr(vk), MAPVK_VK_TO_VSC_EX, hkl)
+	return Keystroke{VK: vk, Scan: uint16(scan), Shift: key>>8&1 != 0}, true
+}
+
+// TypeRune sends r as Unicode input, which needs no key on the layout.
+// Characters outside the BMP go as a surrogate pair.
+func (win32Keys) TypeRune(r rune) error {
+	var inputs []INPUT
+	for _, unit := range utf16.Encode([]rune{r}) {
+		for _, flags := range []uint32{KEYEVENTF_UNICODE, KEYEVENTF_UNICODE | KEYEVENTF_KEYUP} {
+			inputs = append(inputs, INPUT{Type: INPUT_KEYBOARD, Ki: KEYBDINPUT{WScan: unit, DwFlags: flags}})
+		}
+	}
+	return sendInput(inputs...)
+}
+
+// sendInput injects inputs in one uninterruptible batch.
+func sendInput(inputs ...INPUT) error {
+	return sendInputs(len(inputs), unsafe.Pointer(&inputs[0]), unsafe.Sizeof(inputs[0]))
+}
+
+func sendInputs(n int, first unsafe.Pointer, size uintptr) error {
+	sent, _, err := sendInputProc.Call(uintptr(n), uintptr(first), size)
+	if int(sent) != n {
+		return err
+	}
+	return nil
+}
+
+// win32Mouse injects mouse events with SendInput.
+type win32Mouse struct{}
+
+// MoveTo moves in absolute coordinates, which SendInput takes as 0-65535
+// across the virtual desktop spanning every monitor.
+func (win32Mouse) MoveTo(x, y int32) error {
+	metric := func(i uintptr) int32 {
+		v, _, _ := getSystemMetricsProc.Call(i)
+		return int32(v)
+	}
+	left, top := metric(SM_XVIRTUALSCREEN), metric(SM_YVIRTUALSCREEN)
+	width, height := metric(SM_CXVIRTUALSCREEN), metric(SM_CYVIRTUALSCREEN)
+	if width < 2 || height < 2 {
+		return fmt.Errorf("cannot read the size of the screen")
+	}
+	return sendMouse(MOUSEINPUT{
+		Dx:      int32((int64(x-left)*65535 + int64(width-1)/2) / int64(width-1)),
+		Dy:      int32((int64(y-top)*65535 + int64(height-1)/2) / int64(height-1)),
+		DwFlags: MOUSEEVENTF_MOVE | MOUSEEVENTF_ABSOLUTE | MOUSEEVENTF_VIRTUALDESK,
+	})
+}
+
+// buttonFlags are the down and up flags of each button.
+var buttonFlags = map[MouseButton][2]uint32{
+	ButtonLeft:   {MOUSEEVENTF_LEFTDOWN, MOUSEEVENTF_LEFTUP},
+	ButtonRight:  {MOUSEEVENTF_RIGHTDOWN, MOUSEEVENTF_RIGHTUP},
+	ButtonMiddle: {MOUSEEVENTF_MIDDLEDOWN, MOUSEEVENTF_MIDDLEUP},
+}
+
+func (win32Mouse) ButtonDown(b MouseButton) error {
+	return sendMouse(MOUSEINPUT{DwFlags: buttonFlags[b][0]})
+}
+
+func (win32Mouse) ButtonUp(b MouseButton) error {
+	return sendMouse(MOUSEINPUT{DwFlags: buttonFlags[b][1]})
+}
+
+func (win32Mouse) Scroll(dx, dy int32) error {
+	var inputs []mouseINPUT
+	if dy != 0 {
+		inputs = append(inputs, mouseINPUT{Type: INPUT_MOUSE, Mi: MOUSEINPUT{MouseData: uint32(dy * WHEEL_DELTA), DwFlags: MOUSEEVENTF_WHEEL}})
+	}
+	if dx != 0 {
+		inputs = append(inputs, mouseINPUT{Type: INPUT_MOUSE, Mi: MOUSEINPUT{MouseData: uint32(dx * WHEEL_DELTA), DwFlags: MOUSEEVENTF_HWHEEL}})
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	return sendInputs(len(inputs), unsafe.Pointer(&inputs[0]), unsafe.Sizeof(inputs[0]))
+}
+
+func sendMouse(mi MOUSEINPUT) error {
+	input := mouseINPUT{Type: INPUT_MOUSE, Mi: mi}
+	return sendInputs(1, unsafe.Pointer(&input), unsafe.Sizeof(input))
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "testing"
+
+// Windows is polled by wait_for_window and launch_app; Go allows only 2000
+// callbacks per process, so listing must not create one each time.
+func TestWindowsPolledRepeatedly(t *testing.T) {
+	for i := 0; i < 2500; i++ {
+		if _, err := (win32Windows{}).Windows(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
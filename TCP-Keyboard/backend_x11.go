@@ -0,0 +1,447 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const defaultBackend = "x11"
+
+func init() {
+	nativeBackends["x11"] = newX11Backend
+}
+
+// vkToKeysym maps the virtual-key codes in keyTable to X11 keysyms.
+var vkToKeysym = map[byte]uint32{
+	0x08: 0xff08, // BackSpace
+	0x09: 0xff09, // Tab
+	0x0D: 0xff0d, // Return
+	0x10: 0xffe1, // Shift_L
+	0x11: 0xffe3, // Control_L
+	0x12: 0xffe9, // Alt_L
+	0x13: 0xff13, // Pause
+	0x14: 0xffe5, // Caps_Lock
+	0x1B: 0xff1b, // Escape
+	0x20: 0x0020, // space
+	0x21: 0xff55, // Prior
+	0x22: 0xff56, // Next
+	0x23: 0xff57, // End
+	0x24: 0xff50, // Home
+	0x25: 0xff51, // Left
+	0x26: 0xff52, // Up
+	0x27: 0xff53, // Right
+	0x28: 0xff54, // Down
+	0x2C: 0xff61, // Print
+	0x2D: 0xff63, // Insert
+	0x2E: 0xffff, // Delete
+	0x5B: 0xffeb, // Super_L
+	0x5D: 0xff67, // Menu
+	0x6A: 0xffaa, // KP_Multiply
+	0x6B: 0xffab, // KP_Add
+	0x6D: 0xffad, // KP_Subtract
+	0x6E: 0xffae, // KP_Decimal
+	0x6F: 0xffaf, // KP_Divide
+	0x90: 0xff7f, // Num_Lock
+	0x91: 0xff14, // Scroll_Lock
+	0xBA: ';',
+	0xBB: '=',
+	0xBC: ',',
+	0xBD: '-',
+	0xBE: '.',
+	0xBF: '/',
+	0xC0: '`',
+	0xDB: '[',
+	0xDC: '\\',
+	0xDD: ']',
+	0xDE: '\'',
+}
+
+// extendedScanToEvdev maps the E0-prefixed scan codes in keyTable to Linux
+// evdev key codes; other set 1 scan codes are their own evdev code, except
+// Pause, which shares 0x45 with NumLock.
+var extendedScanToEvdev = map[uint16]byte{
+	0x0045: 119, // Pause
+	0xE035: 98,  // KP_Divide
+	0xE037: 99,  // Print
+	0xE045: 69,  // Num_Lock
+	0xE047: 102, // Home
+	0xE048: 103, // Up
+	0xE049: 104, // Prior
+	0xE04B: 105, // Left
+	0xE04D: 106, // Right
+	0xE04F: 107, // End
+	0xE050: 108, // Down
+	0xE051: 109, // Next
+	0xE052: 110, // Insert
+	0xE053: 111, // Delete
+	0xE05B: 125, // Super_L
+	0xE05D: 127, // Menu
+}
+
+func init() {
+	for vk := byte('0'); vk <= '9'; vk++ {
+		vkToKeysym[vk] = uint32(vk)
+	}
+	for vk := byte('A'); vk <= 'Z'; vk++ {
+		vkToKeysym[vk] = uint32(vk - 'A' + 'a')
+	}
+	for i := byte(0); i < 12; i++ {
+		vkToKeysym[0x70+i] = 0xffbe + uint32(i) // F1..F12
+	}
+	for i := byte(0); i < 10; i++ {
+		vkToKeysym[0x60+i] = 0xffb0 + uint32(i) // KP_0..KP_9
+	}
+}
+
+// x11Backend drives an X display: windows through the EWMH hints a window
+// manager publishes, falling back to the raw window tree without one, and
+// keys and the pointer through XTEST.
+type x11Backend struct {
+	x      *x11Conn
+	keys   map[uint32]x11Key
+	codeVK map[byte]byte // keycodes KeyDown can reach, by the virtual key reaching them
+	spare  byte          // unused keycode borrowed by TypeRune, 0 if none
+}
+
+// newX11Backend connects to $DISPLAY.
+func newX11Backend() (*Backend, error) {
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return nil, err
+	}
+	keys, spare, err := x.keyboardMapping()
+	if err != nil {
+		return nil, err
+	}
+	b := &x11Backend{x: x, keys: keys, codeVK: keycodeVKs(keys), spare: spare}
+	return &Backend{Name: "x11", Windows: b, Keys: b, Mouse: b}, nil
+}
+
+// keycodeVKs maps each keycode whose unshifted keysym is in vkToKeysym to
+// the virtual key that reaches it.
+func keycodeVKs(keys map[uint32]x11Key) map[byte]byte {
+	codeVK := map[byte]byte{}
+	for vk, sym := range vkToKeysym {
+		if k, ok := keys[sym]; ok && !k.shift {
+			codeVK[k.code] = vk
+		}
+	}
+	return codeVK
+}
+
+// Windows lists client windows front-most first.
+func (b *x11Backend) Windows() ([]Window, error) {
+	ids, ok, err := b.x.windowsProperty("_NET_CLIENT_LIST_STACKING")
+	if err == nil && !ok {
+		ids, ok, err = b.x.windowsProperty("_NET_CLIENT_LIST")
+	}
+	if err == nil && !ok {
+		// No window manager: use the root's children
+		ids, err = b.x.queryTree(b.x.root)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Stacking order is bottom to top
+	windows := make([]Window, 0, len(ids))
+	exes := map[uint32]string{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		state, err := b.x.mapState(id)
+		if err != nil {
+			continue // closed while we were looking
+		}
+		w := Window{
+			Handle:  WindowHandle(id),
+			Title:   b.title(id),
+			Class:   b.class(id),
+			Visible: state == x11MapStateViewed,
+		}
+		if pid, ok, _ := b.x.listProperty(id, "_NET_WM_PID"); ok && len(pid) > 0 {
+			w.PID = pid[0]
+			exe, seen := exes[w.PID]
+			if !seen {
+				exe = processExe(w.PID)
+				exes[w.PID] = exe
+			}
+			w.Exe = exe
+		}
+		w.Minimized, w.Maximized = b.state(id)
+		w.Rect, _ = b.x.geometry(id)
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// class returns the class half of WM_CLASS, which holds the instance and
+// class names as two NUL-terminated strings.
+func (b *x11Backend) class(id uint32) string {
+	val, _, err := b.x.property(id, x11AtomWMClass)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.TrimRight(string(val), "\x00"), "\x00")
+	return parts[len(parts)-1]
+}
+
+// state reads _NET_WM_STATE for the hidden (minimized) and maximized hints.
+func (b *x11Backend) state(id uint32) (minimized, maximized bool) {
+	atoms, ok, err := b.x.listProperty(id, "_NET_WM_STATE")
+	if err != nil || !ok {
+		return false, false
+	}
+	has := func(name string) bool {
+		a, err := b.x.atom(name)
+		if err != nil || a == 0 {
+			return false
+		}
+		for _, s := range atoms {
+			if s == a {
+				return true
+			}
+		}
+		return false
+	}
+	return has("_NET_WM_STATE_HIDDEN"), has("_NET_WM_STATE_MAXIMIZED_VERT") && has("_NET_WM_STATE_MAXIMIZED_HORZ")
+}
+
+// title returns _NET_WM_NAME, or WM_NAME if that is not set.
+func (b *x11Backend) title(id uint32) string {
+	if a, err := b.x.atom("_NET_WM_NAME"); err == nil && a != 0 {
+		if val, typ, err := b.x.property(id, a); err == nil && typ != 0 && utf8.Valid(val) {
+			return string(val)
+		}
+	}
+	val, typ, err := b.x.property(id, x11AtomWMName)
+	if err != nil || typ == 0 {
+		return ""
+	}
+	// WM_NAME is normally Latin-1
+	runes := make([]rune, len(val))
+	for i, c := range val {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func (b *x11Backend) Foreground() WindowHandle {
+	if ids, ok, err := b.x.windowsProperty("_NET_ACTIVE_WINDOW"); err == nil && ok && len(ids) > 0 {
+		return WindowHandle(ids[0])
+	}
+	focus, err := b.x.inputFocus()
+	if err != nil {
+		return 0
+	}
+	return WindowHandle(focus)
+}
+
+func (b *x11Backend) SetForeground(h WindowHandle) error {
+	if _, ok, err := b.x.windowsProperty("_NET_ACTIVE_WINDOW"); err == nil && ok {
+		// Ask the window manager; source 2 marks us as a pager so it
+		// is not refused by focus-stealing prevention
+		a, err := b.x.atom("_NET_ACTIVE_WINDOW")
+		if err != nil {
+			return err
+		}
+		return b.x.clientMessage(uint32(h), a, 2, 0, 0)
+	}
+	if err := b.x.raise(uint32(h)); err != nil {
+		return err
+	}
+	return b.x.setInputFocus(uint32(h))
+}
+
+func (b *x11Backend) Restore(h WindowHandle) error {
+	// Mapping an iconified window de-iconifies it (ICCCM 4.1.4)
+	return b.x.mapWindow(uint32(h))
+}
+
+// Minimize asks the window manager to iconify h (ICCCM 4.1.4).
+func (b *x11Backend) Minimize(h WindowHandle) error {
+	const iconicState = 3
+	a, err := b.x.atom("WM_CHANGE_STATE")
+	if err != nil || a == 0 {
+		return fmt.Errorf("no window manager to minimize windows: %v", err)
+	}
+	return b.x.clientMessage(uint32(h), a, iconicState)
+}
+
+// Maximize adds both maximized states through _NET_WM_STATE.
+func (b *x11Backend) Maximize(h WindowHandle) error {
+	const add = 1
+	state, err := b.x.atom("_NET_WM_STATE")
+	if err != nil || state == 0 {
+		return fmt.Errorf("no window manager to maximize windows: %v", err)
+	}
+	vert, err := b.x.atom("_NET_WM_STATE_MAXIMIZED_VERT")
+	if err != nil {
+		return err
+	}
+	horz, err := b.x.atom("_NET_WM_STATE_MAXIMIZED_HORZ")
+	if err != nil {
+		return err
+	}
+	return b.x.clientMessage(uint32(h), state, add, vert, horz, 2)
+}
+
+// Close asks the window manager to close h, or without one sends h the
+// WM_DELETE_WINDOW message its close button would.
+func (b *x11Backend) Close(h WindowHandle) error {
+	if _, ok, err := b.x.windowsProperty("_NET_ACTIVE_WINDOW"); err == nil && ok {
+		a, err := b.x.atom("_NET_CLOSE_WINDOW")
+		if err == nil && a != 0 {
+			return b.x.clientMessage(uint32(h), a, 0, 2)
+		}
+	}
+	protocols, err := b.x.atom("WM_PROTOCOLS")
+	if err != nil {
+		return err
+	}
+	del, err := b.x.atom("WM_DELETE_WINDOW")
+	if err != nil {
+		return err
+	}
+	if protocols == 0 || del == 0 {
+		return fmt.Errorf("window %#x does not support being closed politely", h)
+	}
+	return b.x.sendClientMessage(uint32(h), 0, uint32(h), protocols, del, 0)
+}
+
+func (b *x11Backend) Move(h WindowHandle, r Rect) error {
+	return b.x.configure(uint32(h), r)
+}
+
+// ClientRect is the window's own geometry; the window manager's frame is
+// a separate parent window.
+func (b *x11Backend) ClientRect(h WindowHandle) (Rect, error) {
+	return b.x.geometry(uint32(h))
+}
+
+func (b *x11Backend) KeyDown(vk byte) error { return b.key(vk, true) }
+
+func (b *x11Backend) KeyUp(vk byte) error { return b.key(vk, false) }
+
+func (b *x11Backend) key(vk byte, press bool) error {
+	sym, ok := vkToKeysym[vk]
+	if !ok {
+		return fmt.Errorf("no X11 keysym for virtual key %#x", vk)
+	}
+	k, ok := b.keys[sym]
+	if !ok {
+		return fmt.Errorf("keysym %#x is not on the keyboard map", sym)
+	}
+	return b.x.fakeKey(k.code, press)
+}
+
+// ScanDown and ScanUp send the key by position rather than keysym. They
+// assume the usual evdev keycodes, which are 8 above the Linux key codes.
+func (b *x11Backend) ScanDown(scan uint16) error { return b.scan(scan, true) }
+
+func (b *x11Backend) ScanUp(scan uint16) error { return b.scan(scan, false) }
+
+func (b *x11Backend) scan(scan uint16, press bool) error {
+	code, ok := extendedScanToEvdev[scan]
+	if !ok {
+		if scan > 0x7f {
+			return fmt.Errorf("no evdev key for scan code %#x", scan)
+		}
+		code = byte(scan)
+	}
+	return b.x.fakeKey(code+8, press)
+}
+
+// runeKeysym returns the keysym of r: Latin-1 keysyms are the character
+// itself and the rest are the Unicode keysyms.
+func runeKeysym(r rune) uint32 {
+	if r > 0xff {
+		return 0x01000000 | uint32(r)
+	}
+	return uint32(r)
+}
+
+// RuneKeystroke finds r on the keyboard map fetched at connection, which is
+// the layout in use, and returns the virtual key KeyDown sends to the same
+// keycode and the keycode's evdev code as its scan code. Characters on keys
+// that cannot be reached both ways are left to TypeRune.
+func (b *x11Backend) RuneKeystroke(r rune) (Keystroke, bool) {
+	k, ok := b.keys[runeKeysym(r)]
+	if !ok {
+		return Keystroke{}, false
+	}
+	vk, ok := b.codeVK[k.code]
+	scan := k.code - 8
+	if !ok || k.code < 8 || scan >= 0x80 || scan == 0x45 {
+		return Keystroke{}, false
+	}
+	return Keystroke{VK: vk, Scan: uint16(scan), Shift: k.shift}, true
+}
+
+// TypeRune types r with the key that produces it, or failing that by
+// temporarily mapping it onto the spare keycode, as xdotool does. The spare
+// is left mapped so the target has time to look it up.
+func (b *x11Backend) TypeRune(r rune) error {
+	sym := runeKeysym(r)
+	k, ok := b.keys[sym]
+	if !ok {
+		if b.spare == 0 {
+			return fmt.Errorf("no key for %q and no spare keycode to map it to", r)
+		}
+		if err := b.x.setKeysym(b.spare, sym); err != nil {
+			return err
+		}
+		k = x11Key{code: b.spare}
+	}
+	if k.shift {
+		if err := b.key(vkShift, true); err != nil {
+			return err
+		}
+		defer b.key(vkShift, false)
+	}
+	if err := b.x.fakeKey(k.code, true); err != nil {
+		return err
+	}
+	return b.x.fakeKey(k.code, false)
+}
+
+func (b *x11Backend) MoveTo(x, y int32) error {
+	return b.x.fakeMotion(x, y)
+}
+
+// x11Buttons are the core pointer button numbers.
+var x11Buttons = map[MouseButton]byte{ButtonLeft: 1, ButtonMiddle: 2, ButtonRight: 3}
+
+func (b *x11Backend) ButtonDown(mb MouseButton) error {
+	return b.x.fakeButton(x11Buttons[mb], true)
+}
+
+func (b *x11Backend) ButtonUp(mb MouseButton) error {
+	return b.x.fakeButton(x11Buttons[mb], false)
+}
+
+// Scroll clicks the wheel buttons once per notch: 4 and 5 are up and down,
+// 6 and 7 left and right.
+func (b *x11Backend) Scroll(dx, dy int32) error {
+	for _, axis := range []struct {
+		n        int32
+		pos, neg byte
+	}{{dy, 4, 5}, {dx, 7, 6}} {
+		button, n := axis.pos, axis.n
+		if n < 0 {
+			button, n = axis.neg, -n
+		}
+		for ; n > 0; n-- {
+			if err := b.x.fakeButton(button, true); err != nil {
+				return err
+			}
+			if err := b.x.fakeButton(button, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Bounds is the default and allowed range of a per-request setting.
+type Bounds struct {
+	Default int `json:"default"`
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+}
+
+// TimingConfig bounds the timing fields a request may set, in milliseconds.
+type TimingConfig struct {
+	KeyDownMs      Bounds `json:"key_down_ms"`
+	KeyGapMs       Bounds `json:"key_gap_ms"`
+	FocusTimeoutMs Bounds `json:"focus_timeout_ms"`
+}
+
+// AppConfig is an application launch_app may start. Args are always passed
+// first; a request may add its own only if AllowExtraArgs is set, and may
+// pick a working directory only from WorkingDirs.
+type AppConfig struct {
+	Path           string   `json:"path"`
+	Args           []string `json:"args"`
+	Dir            string   `json:"dir"` // default working directory
+	AllowExtraArgs bool     `json:"allow_extra_args"`
+	WorkingDirs    []string `json:"working_dirs"` // directories a request's working_dir may name
+}
+
+// Config is the server configuration loaded with -config. Sections left out
+// of the file keep their defaults.
+type Config struct {
+	Timing TimingConfig         `json:"timing"`
+	Apps   map[string]AppConfig `json:"apps"` // by the id requests use
+}
+
+// config is the active configuration.
+var config = Config{
+	Timing: TimingConfig{
+		KeyDownMs:      Bounds{Default: 50, Min: 1, Max: 5000},
+		KeyGapMs:       Bounds{Default: 50, Min: 0, Max: 5000},
+		FocusTimeoutMs: Bounds{Default: 2000, Min: 100, Max: 30000},
+	},
+}
+
+// loadConfig reads a JSON config file over the defaults, e.g.
+//
+//	{
+//	  "timing": {"key_down_ms": {"default": 80, "min": 20, "max": 1000}},
+//	  "apps": {"sim": {"path": "C:\\Games\\Sim\\sim.exe", "args": ["-fullscreen"]}}
+//	}
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, b := range map[string]Bounds{
+		"key_down_ms":      config.Timing.KeyDownMs,
+		"key_gap_ms":       config.Timing.KeyGapMs,
+		"focus_timeout_ms": config.Timing.FocusTimeoutMs,
+	} {
+		if b.Min < 0 || b.Min > b.Default || b.Default > b.Max {
+			return fmt.Errorf("%s: timing.%s needs 0 <= min <= default <= max", path, name)
+		}
+	}
+	for id, app := range config.Apps {
+		if !filepath.IsAbs(app.Path) {
+			return fmt.Errorf("%s: apps.%s needs an absolute path", path, id)
+		}
+		for _, dir := range app.WorkingDirs {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("%s: apps.%s.working_dirs needs absolute paths", path, id)
+			}
+		}
+	}
+	return nil
+}
+
+// TimingRequest holds the optional timing fields of a request.
+type TimingRequest struct {
+	KeyDownMs      *int `json:"key_down_ms"`
+	KeyGapMs       *int `json:"key_gap_ms"`
+	FocusTimeoutMs *int `json:"focus_timeout_ms"`
+}
+
+// Timing is how long keys are held, the pause after each key event, and how
+// long to wait for the target window to take focus.
+type Timing struct {
+	KeyDown      time.Duration
+	KeyGap       time.Duration
+	FocusTimeout time.Duration
+}
+
+// resolve fills in defaults and checks the requested values against the
+// configured bounds.
+func (r TimingRequest) resolve() (Timing, error) {
+	var t Timing
+	for _, f := range []struct {
+		name string
+		req  *int
+		b    Bounds
+		out  *time.Duration
+	}{
+		{"key_down_ms", r.KeyDownMs, config.Timing.KeyDownMs, &t.KeyDown},
+		{"key_gap_ms", r.KeyGapMs, config.Timing.KeyGapMs, &t.KeyGap},
+		{"focus_timeout_ms", r.FocusTimeoutMs, config.Timing.FocusTimeoutMs, &t.FocusTimeout},
+	} {
+		ms := f.b.Default
+		if f.req != nil {
+			ms = *f.req
+			if ms < f.b.Min || ms > f.b.Max {
+				return Timing{}, fmt.Errorf("%s must be between %d and %d", f.name, f.b.Min, f.b.Max)
+			}
+		}
+		*f.out = time.Duration(ms) * time.Millisecond
+	}
+	return t, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// QueueRequest holds the optional queueing fields of a request that sends
+// input. Higher priorities run first; equal priorities run in arrival order.
+type QueueRequest struct {
+	Priority       int `json:"priority"`
+	MaxQueueWaitMs int `json:"max_queue_wait_ms"`
+}
+
+// QueueInfo is reported with every queued request: how many requests were
+// ahead of it when it arrived, and how long it waited to start.
+type QueueInfo struct {
+	Position int   `json:"position"`
+	WaitMs   int64 `json:"wait_ms"`
+}
+
+// inputJob is one request waiting for, or holding, the input executor.
+type inputJob struct {
+	priority int
+	seq      uint64
+	queued   time.Time
+	run      func() string
+
+	index   int // in the heap, -1 once taken off it
+	started chan struct{}
+	wait    time.Duration // set when started
+	result  string        // set before done is closed
+	done    chan struct{}
+}
+
+// jobHeap orders jobs by priority, then arrival.
+type jobHeap []*inputJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*inputJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	job.index = -1
+	*h = old[:len(old)-1]
+	return job
+}
+
+func (j *inputJob) before(k *inputJob) bool {
+	if j.priority != k.priority {
+		return j.priority > k.priority
+	}
+	return j.seq < k.seq
+}
+
+// inputExecutor runs requests that focus windows or send input one at a
+// time, so that two clients cannot steal focus from each other or
+// interleave their keystrokes.
+type inputExecutor struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   jobHeap
+	seq     uint64
+	running bool
+}
+
+// executor is the single executor all connections share.
+var executor = newInputExecutor()
+
+func newInputExecutor() *inputExecutor {
+	e := &inputExecutor{}
+	e.cond = sync.NewCond(&e.mu)
+	go e.loop()
+	return e
+}
+
+func (e *inputExecutor) loop() {
+	for {
+		e.mu.Lock()
+		for len(e.queue) == 0 {
+			e.cond.Wait()
+		}
+		job := heap.Pop(&e.queue).(*inputJob)
+		job.wait = time.Since(job.queued)
+		e.running = true
+		e.mu.Unlock()
+
+		close(job.started)
+		job.result = e.execute(job)
+		close(job.done)
+
+		e.mu.Lock()
+		e.running = false
+		e.mu.Unlock()
+	}
+}
+
+// execute runs job, turning a panic into an error response so the
+// executor keeps serving.
+func (e *inputExecutor) execute(job *inputJob) (resp string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in queued request: %v\n", r)
+			resp = toJSON("error", fmt.Sprintf("Internal error: %v", r), nil)
+		}
+	}()
+	return job.run()
+}
+
+// do queues run and waits for its response, to which it adds a "queue"
+// field with the QueueInfo. If req.MaxQueueWaitMs is set and run has not
+// started by then, it is dropped and an error returned instead.
+func (e *inputExecutor) do(req QueueRequest, run func() string) string {
+	if req.MaxQueueWaitMs < 0 {
+		return toJSON("error", "max_queue_wait_ms must not be negative", nil)
+	}
+
+	job := &inputJob{
+		priority: req.Priority,
+		queued:   time.Now(),
+		run:      run,
+		started:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	e.mu.Lock()
+	e.seq++
+	job.seq = e.seq
+	var info QueueInfo
+	for _, other := range e.queue {
+		if other.before(job) {
+			info.Position++
+		}
+	}
+	if e.running {
+		info.Position++
+	}
+	heap.Push(&e.queue, job)
+	e.cond.Signal()
+	e.mu.Unlock()
+
+	if req.MaxQueueWaitMs > 0 {
+		timer := time.NewTimer(time.Duration(req.MaxQueueWaitMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-job.started:
+		case <-timer.C:
+			e.mu.Lock()
+			if job.index >= 0 {
+				heap.Remove(&e.queue, job.index)
+				e.mu.Unlock()
+				info.WaitMs = time.Since(job.queued).Milliseconds()
+				log.Printf("Request dropped after waiting %d ms in the input queue\n", info.WaitMs)
+				return toJSON("error", fmt.Sprintf("Timed out after %d ms in the input queue", req.MaxQueueWaitMs),
+					map[string]interface{}{"queue": info})
+			}
+			e.mu.Unlock() // started just now
+		}
+	}
+	<-job.done
+
+	info.WaitMs = job.wait.Milliseconds()
+	return withFields(job.result, map[string]interface{}{"queue": info})
+}
+
+// withFields adds fields to a JSON object response.
+func withFields(resp string, fields map[string]interface{}) string {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(resp), &m); err != nil {
+		return resp
+	}
+	for k, v := range fields {
+		m[k] = v
+	}
+	out, _ := json.Marshal(m)
+	return string(out)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued waits until n jobs are waiting on e.
+func queued(t *testing.T, e *inputExecutor, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.mu.Lock()
+		got := len(e.queue)
+		e.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs queued, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockExecutor starts a job that holds e until the returned func is called.
+func blockExecutor(t *testing.T, e *inputExecutor) (release func()) {
+	t.Helper()
+	started, hold := make(chan struct{}), make(chan struct{})
+	go e.do(QueueRequest{}, func() string {
+		close(started)
+		<-hold
+		return toJSON("success", "blocker", nil)
+	})
+	<-started
+	return func() { close(hold) }
+}
+
+func decodeQueue(t *testing.T, resp string) (status string, info QueueInfo) {
+	t.Helper()
+	var m struct {
+		Status string `json:"status"`
+		Data   struct {
+			Queue QueueInfo `json:"queue"`
+		} `json:"data"`
+		Queue QueueInfo `json:"queue"`
+	}
+	if err := json.Unmarshal([]byte(resp), &m); err != nil {
+		t.Fatalf("response is not JSON: %s", resp)
+	}
+	if m.Status == "error" {
+		return m.Status, m.Data.Queue
+	}
+	return m.Status, m.Queue
+}
+
+func TestExecutorPriority(t *testing.T) {
+	e := newInputExecutor()
+	release := blockExecutor(t, e)
+
+	// Submitted one by one so that each one's position is known
+	jobs := []struct {
+		name     string
+		priority int
+		position int
+	}{
+		{"low", 0, 1},
+		{"high", 5, 1},
+		{"low2", 0, 3},
+		{"mid", 1, 2},
+		{"high2", 5, 2},
+	}
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	resps := make([]string, len(jobs))
+	for i, j := range jobs {
+		i, j := i, j
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resps[i] = e.do(QueueRequest{Priority: j.priority}, func() string {
+				mu.Lock()
+				order = append(order, j.name)
+				mu.Unlock()
+				return toJSON("success", j.name, nil)
+			})
+		}()
+		queued(t, e, i+1)
+	}
+	release()
+	wg.Wait()
+
+	if got := strings.Join(order, " "); got != "high high2 mid low low2" {
+		t.Errorf("ran %s", got)
+	}
+	for i, j := range jobs {
+		status, info := decodeQueue(t, resps[i])
+		if status != "success" || info.Position != j.position {
+			t.Errorf("%s: %s at position %d, want success at %d", j.name, status, info.Position, j.position)
+		}
+	}
+}
+
+func TestExecutorMaxQueueWait(t *testing.T) {
+	e := newInputExecutor()
+	release := blockExecutor(t, e)
+
+	ran := make(chan string, 2)
+	dropped := make(chan string)
+	go func() {
+		dropped <- e.do(QueueRequest{MaxQueueWaitMs: 20}, func() string {
+			ran <- "dropped"
+			return toJSON("success", "", nil)
+		})
+	}()
+	kept := make(chan string)
+	go func() {
+		kept <- e.do(QueueRequest{MaxQueueWaitMs: 5000}, func() string {
+			ran <- "kept"
+			return toJSON("success", "", nil)
+		})
+	}()
+
+	resp := <-dropped
+	status, info := decodeQueue(t, resp)
+	if status != "error" || !strings.Contains(resp, "Timed out after 20 ms in the input queue") {
+		t.Errorf("got %s", resp)
+	}
+	if info.WaitMs < 20 {
+		t.Errorf("dropped after %d ms", info.WaitMs)
+	}
+	queued(t, e, 1)
+
+	release()
+	if status, _ := decodeQueue(t, <-kept); status != "success" {
+		t.Errorf("job within its wait: %s", status)
+	}
+	close(ran)
+	for name := range ran {
+		if name != "kept" {
+			t.Errorf("%s job ran", name)
+		}
+	}
+}
+
+func TestExecutorPanic(t *testing.T) {
+	e := newInputExecutor()
+	resp := e.do(QueueRequest{}, func() string { panic("boom") })
+	if status, _ := decodeQueue(t, resp); status != "error" || !strings.Contains(resp, "boom") {
+		t.Errorf("got %s", resp)
+	}
+	resp = e.do(QueueRequest{}, func() string { return toJSON("success", "", nil) })
+	if status, _ := decodeQueue(t, resp); status != "success" {
+		t.Errorf("after a panic: %s", resp)
+	}
+	if resp := e.do(QueueRequest{MaxQueueWaitMs: -1}, nil); !strings.Contains(resp, "must not be negative") {
+		t.Errorf("negative wait: %s", resp)
+	}
+}
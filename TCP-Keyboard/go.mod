@@ -0,0 +1,3 @@
+module TCP-Keyboard
+
+go 1.21
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Injection modes a request can ask for.
+const (
+	modeVK       = "vk"       // virtual-key codes, which desktop apps read
+	modeScanCode = "scancode" // hardware scan codes, which DirectInput and raw input read
+)
+
+// keySender injects the keys of one request in the mode and with the
+// timing it asked for. It remembers which keys it has pressed and not yet
+// released, so that guard can let go of them whatever happens.
+type keySender struct {
+	scan   ScanCodeInjector // set in scancode mode
+	timing Timing
+	held   []Keystroke // pressed and not released, in press order
+}
+
+// newKeySender returns a sender for mode, "vk" if empty.
+func newKeySender(mode string, timing Timing) (*keySender, error) {
+	switch mode {
+	case "", modeVK:
+		return &keySender{timing: timing}, nil
+	case modeScanCode:
+		scan, ok := backend.Keys.(ScanCodeInjector)
+		if !ok {
+			return nil, fmt.Errorf("scancode mode is not supported by the %s backend", backend.Name)
+		}
+		return &keySender{scan: scan, timing: timing}, nil
+	}
+	return nil, fmt.Errorf("unknown mode '%s' (use vk or scancode)", mode)
+}
+
+func (s *keySender) down(ks Keystroke) error {
+	// Count it as held even if sending failed, so it is released anyway
+	s.held = append(s.held, ks)
+	if s.scan != nil {
+		return s.scan.ScanDown(ks.Scan)
+	}
+	return backend.Keys.KeyDown(ks.VK)
+}
+
+func (s *keySender) up(ks Keystroke) error {
+	s.held = release(s.held, ks.VK)
+	if s.scan != nil {
+		return s.scan.ScanUp(ks.Scan)
+	}
+	return backend.Keys.KeyUp(ks.VK)
+}
+
+// gap pauses between key events.
+func (s *keySender) gap() {
+	time.Sleep(s.timing.KeyGap)
+}
+
+// tap presses a non-modifier key, holds it for hold and releases it.
+// Shifted characters get shift wrapped around them, unless shift is already
+// held.
+func (s *keySender) tap(ks Keystroke, hold time.Duration) error {
+	wrap := ks.Shift && !holding(s.held, vkShift)
+	if wrap {
+		if err := s.down(keyShift); err != nil {
+			return err
+		}
+		s.gap()
+	}
+	if err := s.down(ks); err != nil {
+		return err
+	}
+	time.Sleep(hold)
+	if err := s.up(ks); err != nil {
+		return err
+	}
+	s.gap()
+	if wrap {
+		if err := s.up(keyShift); err != nil {
+			return err
+		}
+		s.gap()
+	}
+	return nil
+}
+
+// chord holds the chord's modifiers, other than those already held, while
+// its key is tapped, then releases them in reverse order.
+func (s *keySender) chord(c Chord, hold time.Duration) error {
+	var pressed []Keystroke
+	for _, m := range c.Modifiers {
+		if holding(s.held, m.VK) {
+			continue
+		}
+		if err := s.down(m); err != nil {
+			return err
+		}
+		pressed = append(pressed, m)
+		s.gap()
+	}
+	if err := s.tap(c.Key, hold); err != nil {
+		return err
+	}
+	for i := len(pressed) - 1; i >= 0; i-- {
+		if err := s.up(pressed[i]); err != nil {
+			return err
+		}
+		s.gap()
+	}
+	return nil
+}
+
+// releaseAll releases every key still held, in press order. It carries on
+// past errors and returns the first.
+func (s *keySender) releaseAll() error {
+	var first error
+	for len(s.held) > 0 {
+		if err := s.up(s.held[0]); err != nil && first == nil {
+			first = err
+		}
+		s.gap()
+	}
+	return first
+}
+
+// guard is deferred by handlers once they start injecting. It releases any
+// keys still held after an error return, and turns a panic into an error
+// response instead of leaving keys down and killing the server.
+func (s *keySender) guard(resp *string) {
+	if r := recover(); r != nil {
+		log.Printf("Panic while sending keys: %v\n", r)
+		*resp = toJSON("error", fmt.Sprintf("Internal error: %v", r), nil)
+	}
+	if len(s.held) > 0 {
+		log.Printf("Releasing %d keys left held\n", len(s.held))
+		s.releaseAll()
+	}
+}
+
+// holding reports whether the key with virtual-key code vk is held.
+func holding(held []Keystroke, vk byte) bool {
+	for _, h := range held {
+		if h.VK == vk {
+			return true
+		}
+	}
+	return false
+}
+
+// release removes the key with virtual-key code vk from the held keys.
+func release(held []Keystroke, vk byte) []Keystroke {
+	out := held[:0]
+	for _, h := range held {
+		if h.VK != vk {
+			out = append(out, h)
+		}
+	}
+	return out
+}
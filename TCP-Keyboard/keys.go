@@ -0,0 +1,250 @@
+package main
+
+import "strings"
+
+// getAllowedKeys returns the accepted key names by group.
+func getAllowedKeys() map[string][]string {
+	allowed := map[string][]string{}
+	for _, g := range keyGroups {
+		for _, k := range g.keys {
+			allowed[g.name] = append(allowed[g.name], k.name)
+		}
+	}
+	return allowed
+}
+
+func isModifierKey(key string) bool {
+	return modifierKeys[key]
+}
+
+// Keystroke is what it takes to produce a key: its Windows virtual-key code,
+// its set 1 hardware scan code, and whether Shift must be held while it is
+// pressed. Scan codes of extended keys (arrows, navigation keys, numpad /
+// and so on) carry the 0xE0 prefix in their high byte. Backends on other
+// platforms translate the codes to their own.
+type Keystroke struct {
+	VK    byte
+	Scan  uint16
+	Shift bool
+}
+
+// Extended reports whether the key's scan code has the 0xE0 prefix.
+func (k Keystroke) Extended() bool {
+	return k.Scan>>8 == 0xE0
+}
+
+// vkShift is the virtual-key code of the Shift key.
+const vkShift = 0x10
+
+// keyShift is the keystroke for the left Shift key.
+var keyShift = Keystroke{VK: vkShift, Scan: 0x2A}
+
+// keyGroups lists every accepted key name with its keystroke, in the groups
+// help shows them in. keyTable and getAllowedKeys are built from it.
+// Characters that share a key with another (the "!" on "1") are marked
+// Shift so they type what they name on a US layout.
+var keyGroups = []struct {
+	name string
+	keys []keyDef
+}{
+	{"alphabet", []keyDef{
+		{"a", Keystroke{VK: 0x41, Scan: 0x1E}},
+		{"b", Keystroke{VK: 0x42, Scan: 0x30}},
+		{"c", Keystroke{VK: 0x43, Scan: 0x2E}},
+		{"d", Keystroke{VK: 0x44, Scan: 0x20}},
+		{"e", Keystroke{VK: 0x45, Scan: 0x12}},
+		{"f", Keystroke{VK: 0x46, Scan: 0x21}},
+		{"g", Keystroke{VK: 0x47, Scan: 0x22}},
+		{"h", Keystroke{VK: 0x48, Scan: 0x23}},
+		{"i", Keystroke{VK: 0x49, Scan: 0x17}},
+		{"j", Keystroke{VK: 0x4A, Scan: 0x24}},
+		{"k", Keystroke{VK: 0x4B, Scan: 0x25}},
+		{"l", Keystroke{VK: 0x4C, Scan: 0x26}},
+		{"m", Keystroke{VK: 0x4D, Scan: 0x32}},
+		{"n", Keystroke{VK: 0x4E, Scan: 0x31}},
+		{"o", Keystroke{VK: 0x4F, Scan: 0x18}},
+		{"p", Keystroke{VK: 0x50, Scan: 0x19}},
+		{"q", Keystroke{VK: 0x51, Scan: 0x10}},
+		{"r", Keystroke{VK: 0x52, Scan: 0x13}},
+		{"s", Keystroke{VK: 0x53, Scan: 0x1F}},
+		{"t", Keystroke{VK: 0x54, Scan: 0x14}},
+		{"u", Keystroke{VK: 0x55, Scan: 0x16}},
+		{"v", Keystroke{VK: 0x56, Scan: 0x2F}},
+		{"w", Keystroke{VK: 0x57, Scan: 0x11}},
+		{"x", Keystroke{VK: 0x58, Scan: 0x2D}},
+		{"y", Keystroke{VK: 0x59, Scan: 0x15}},
+		{"z", Keystroke{VK: 0x5A, Scan: 0x2C}},
+	}},
+	{"numbers", []keyDef{
+		{"0", Keystroke{VK: 0x30, Scan: 0x0B}},
+		{"1", Keystroke{VK: 0x31, Scan: 0x02}},
+		{"2", Keystroke{VK: 0x32, Scan: 0x03}},
+		{"3", Keystroke{VK: 0x33, Scan: 0x04}},
+		{"4", Keystroke{VK: 0x34, Scan: 0x05}},
+		{"5", Keystroke{VK: 0x35, Scan: 0x06}},
+		{"6", Keystroke{VK: 0x36, Scan: 0x07}},
+		{"7", Keystroke{VK: 0x37, Scan: 0x08}},
+		{"8", Keystroke{VK: 0x38, Scan: 0x09}},
+		{"9", Keystroke{VK: 0x39, Scan: 0x0A}},
+	}},
+	{"function", []keyDef{
+		{"f1", Keystroke{VK: 0x70, Scan: 0x3B}},
+		{"f2", Keystroke{VK: 0x71, Scan: 0x3C}},
+		{"f3", Keystroke{VK: 0x72, Scan: 0x3D}},
+		{"f4", Keystroke{VK: 0x73, Scan: 0x3E}},
+		{"f5", Keystroke{VK: 0x74, Scan: 0x3F}},
+		{"f6", Keystroke{VK: 0x75, Scan: 0x40}},
+		{"f7", Keystroke{VK: 0x76, Scan: 0x41}},
+		{"f8", Keystroke{VK: 0x77, Scan: 0x42}},
+		{"f9", Keystroke{VK: 0x78, Scan: 0x43}},
+		{"f10", Keystroke{VK: 0x79, Scan: 0x44}},
+		{"f11", Keystroke{VK: 0x7A, Scan: 0x57}},
+		{"f12", Keystroke{VK: 0x7B, Scan: 0x58}},
+	}},
+	{"control", []keyDef{
+		{"enter", Keystroke{VK: 0x0D, Scan: 0x1C}},
+		{"return", Keystroke{VK: 0x0D, Scan: 0x1C}},
+		{"tab", Keystroke{VK: 0x09, Scan: 0x0F}},
+		{"backspace", Keystroke{VK: 0x08, Scan: 0x0E}},
+		{"space", Keystroke{VK: 0x20, Scan: 0x39}},
+		{"escape", Keystroke{VK: 0x1B, Scan: 0x01}},
+		{"delete", Keystroke{VK: 0x2E, Scan: 0xE053}},
+		{"insert", Keystroke{VK: 0x2D, Scan: 0xE052}},
+		{"home", Keystroke{VK: 0x24, Scan: 0xE047}},
+		{"end", Keystroke{VK: 0x23, Scan: 0xE04F}},
+		{"pageup", Keystroke{VK: 0x21, Scan: 0xE049}},
+		{"pagedown", Keystroke{VK: 0x22, Scan: 0xE051}},
+		{"printscreen", Keystroke{VK: 0x2C, Scan: 0xE037}},
+		{"pause", Keystroke{VK: 0x13, Scan: 0x45}},
+	}},
+	{"arrows", []keyDef{
+		{"left", Keystroke{VK: 0x25, Scan: 0xE04B}},
+		{"up", Keystroke{VK: 0x26, Scan: 0xE048}},
+		{"right", Keystroke{VK: 0x27, Scan: 0xE04D}},
+		{"down", Keystroke{VK: 0x28, Scan: 0xE050}},
+	}},
+	{"modifiers", []keyDef{
+		{"shift", Keystroke{VK: 0x10, Scan: 0x2A}},
+		{"ctrl", Keystroke{VK: 0x11, Scan: 0x1D}},
+		{"control", Keystroke{VK: 0x11, Scan: 0x1D}},
+		{"alt", Keystroke{VK: 0x12, Scan: 0x38}},
+		{"capslock", Keystroke{VK: 0x14, Scan: 0x3A}},
+		{"caps", Keystroke{VK: 0x14, Scan: 0x3A}},
+		{"numlock", Keystroke{VK: 0x90, Scan: 0xE045}},
+		{"scroll", Keystroke{VK: 0x91, Scan: 0x46}},
+		{"menu", Keystroke{VK: 0x5D, Scan: 0xE05D}},
+		{"super", Keystroke{VK: 0x5B, Scan: 0xE05B}},
+		{"win", Keystroke{VK: 0x5B, Scan: 0xE05B}},
+	}},
+	{"special", []keyDef{
+		{"!", Keystroke{VK: 0x31, Scan: 0x02, Shift: true}},
+		{"@", Keystroke{VK: 0x32, Scan: 0x03, Shift: true}},
+		{"#", Keystroke{VK: 0x33, Scan: 0x04, Shift: true}},
+		{"$", Keystroke{VK: 0x34, Scan: 0x05, Shift: true}},
+		{"%", Keystroke{VK: 0x35, Scan: 0x06, Shift: true}},
+		{"^", Keystroke{VK: 0x36, Scan: 0x07, Shift: true}},
+		{"&", Keystroke{VK: 0x37, Scan: 0x08, Shift: true}},
+		{"*", Keystroke{VK: 0x38, Scan: 0x09, Shift: true}},
+		{"(", Keystroke{VK: 0x39, Scan: 0x0A, Shift: true}},
+		{")", Keystroke{VK: 0x30, Scan: 0x0B, Shift: true}},
+		{"-", Keystroke{VK: 0xBD, Scan: 0x0C}},
+		{"_", Keystroke{VK: 0xBD, Scan: 0x0C, Shift: true}},
+		{"=", Keystroke{VK: 0xBB, Scan: 0x0D}},
+		{"+", Keystroke{VK: 0xBB, Scan: 0x0D, Shift: true}},
+		{"[", Keystroke{VK: 0xDB, Scan: 0x1A}},
+		{"{", Keystroke{VK: 0xDB, Scan: 0x1A, Shift: true}},
+		{"]", Keystroke{VK: 0xDD, Scan: 0x1B}},
+		{"}", Keystroke{VK: 0xDD, Scan: 0x1B, Shift: true}},
+		{";", Keystroke{VK: 0xBA, Scan: 0x27}},
+		{":", Keystroke{VK: 0xBA, Scan: 0x27, Shift: true}},
+		{"'", Keystroke{VK: 0xDE, Scan: 0x28}},
+		{"\"", Keystroke{VK: 0xDE, Scan: 0x28, Shift: true}},
+		{",", Keystroke{VK: 0xBC, Scan: 0x33}},
+		{"<", Keystroke{VK: 0xBC, Scan: 0x33, Shift: true}},
+		{".", Keystroke{VK: 0xBE, Scan: 0x34}},
+		{">", Keystroke{VK: 0xBE, Scan: 0x34, Shift: true}},
+		{"/", Keystroke{VK: 0xBF, Scan: 0x35}},
+		{"?", Keystroke{VK: 0xBF, Scan: 0x35, Shift: true}},
+		{"`", Keystroke{VK: 0xC0, Scan: 0x29}},
+		{"~", Keystroke{VK: 0xC0, Scan: 0x29, Shift: true}},
+	}},
+	{"numpad", []keyDef{
+		{"numpad0", Keystroke{VK: 0x60, Scan: 0x52}},
+		{"numpad1", Keystroke{VK: 0x61, Scan: 0x4F}},
+		{"numpad2", Keystroke{VK: 0x62, Scan: 0x50}},
+		{"numpad3", Keystroke{VK: 0x63, Scan: 0x51}},
+		{"numpad4", Keystroke{VK: 0x64, Scan: 0x4B}},
+		{"numpad5", Keystroke{VK: 0x65, Scan: 0x4C}},
+		{"numpad6", Keystroke{VK: 0x66, Scan: 0x4D}},
+		{"numpad7", Keystroke{VK: 0x67, Scan: 0x47}},
+		{"numpad8", Keystroke{VK: 0x68, Scan: 0x48}},
+		{"numpad9", Keystroke{VK: 0x69, Scan: 0x49}},
+		{"numpad*", Keystroke{VK: 0x6A, Scan: 0x37}},
+		{"numpad+", Keystroke{VK: 0x6B, Scan: 0x4E}},
+		{"numpad-", Keystroke{VK: 0x6D, Scan: 0x4A}},
+		{"numpad.", Keystroke{VK: 0x6E, Scan: 0x53}},
+		{"numpad/", Keystroke{VK: 0x6F, Scan: 0xE035}},
+	}},
+}
+
+// keyDef is one named key in keyGroups.
+type keyDef struct {
+	name string
+	ks   Keystroke
+}
+
+// keyTable maps every accepted key name to its keystroke.
+var keyTable = map[string]Keystroke{}
+
+// modifierKeys are the names in the modifiers group.
+var modifierKeys = map[string]bool{}
+
+func init() {
+	for _, g := range keyGroups {
+		for _, k := range g.keys {
+			keyTable[k.name] = k.ks
+			if g.name == "modifiers" {
+				modifierKeys[k.name] = true
+			}
+		}
+	}
+}
+
+// lookupKey returns the keystroke for a key name, ignoring case.
+func lookupKey(key string) (Keystroke, bool) {
+	ks, ok := keyTable[strings.ToLower(key)]
+	return ks, ok
+}
+
+// runeKeystroke returns the keystroke that types r: spaces, newlines and
+// tabs are their keys, and other characters are looked up in the backend's
+// keyboard layout when it can, or else on a US layout.
+func runeKeystroke(r rune) (Keystroke, bool) {
+	switch r {
+	case ' ':
+		return keyTable["space"], true
+	case '\n':
+		return keyTable["enter"], true
+	case '\t':
+		return keyTable["tab"], true
+	}
+	if m, ok := backend.Keys.(LayoutMapper); ok {
+		return m.RuneKeystroke(r)
+	}
+	return usKeystroke(r)
+}
+
+// usKeystroke returns the keystroke that types r on a US layout: capitals
+// are their letter with shift and everything else is looked up in keyTable.
+func usKeystroke(r rune) (Keystroke, bool) {
+	switch {
+	case r >= 'A' && r <= 'Z':
+		ks := keyTable[string(r-'A'+'a')]
+		ks.Shift = true
+		return ks, true
+	case r < 0x80 && r > ' ':
+		ks, ok := keyTable[string(r)]
+		return ks, ok
+	}
+	return Keystroke{}, false
+}
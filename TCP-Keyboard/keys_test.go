@@ -0,0 +1,212 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wantKeys is the keystroke every accepted key name must resolve to.
+var wantKeys = map[string]Keystroke{
+	"a":           {VK: 0x41, Scan: 0x1E},
+	"b":           {VK: 0x42, Scan: 0x30},
+	"c":           {VK: 0x43, Scan: 0x2E},
+	"d":           {VK: 0x44, Scan: 0x20},
+	"e":           {VK: 0x45, Scan: 0x12},
+	"f":           {VK: 0x46, Scan: 0x21},
+	"g":           {VK: 0x47, Scan: 0x22},
+	"h":           {VK: 0x48, Scan: 0x23},
+	"i":           {VK: 0x49, Scan: 0x17},
+	"j":           {VK: 0x4A, Scan: 0x24},
+	"k":           {VK: 0x4B, Scan: 0x25},
+	"l":           {VK: 0x4C, Scan: 0x26},
+	"m":           {VK: 0x4D, Scan: 0x32},
+	"n":           {VK: 0x4E, Scan: 0x31},
+	"o":           {VK: 0x4F, Scan: 0x18},
+	"p":           {VK: 0x50, Scan: 0x19},
+	"q":           {VK: 0x51, Scan: 0x10},
+	"r":           {VK: 0x52, Scan: 0x13},
+	"s":           {VK: 0x53, Scan: 0x1F},
+	"t":           {VK: 0x54, Scan: 0x14},
+	"u":           {VK: 0x55, Scan: 0x16},
+	"v":           {VK: 0x56, Scan: 0x2F},
+	"w":           {VK: 0x57, Scan: 0x11},
+	"x":           {VK: 0x58, Scan: 0x2D},
+	"y":           {VK: 0x59, Scan: 0x15},
+	"z":           {VK: 0x5A, Scan: 0x2C},
+	"0":           {VK: 0x30, Scan: 0x0B},
+	"1":           {VK: 0x31, Scan: 0x02},
+	"2":           {VK: 0x32, Scan: 0x03},
+	"3":           {VK: 0x33, Scan: 0x04},
+	"4":           {VK: 0x34, Scan: 0x05},
+	"5":           {VK: 0x35, Scan: 0x06},
+	"6":           {VK: 0x36, Scan: 0x07},
+	"7":           {VK: 0x37, Scan: 0x08},
+	"8":           {VK: 0x38, Scan: 0x09},
+	"9":           {VK: 0x39, Scan: 0x0A},
+	"f1":          {VK: 0x70, Scan: 0x3B},
+	"f2":          {VK: 0x71, Scan: 0x3C},
+	"f3":          {VK: 0x72, Scan: 0x3D},
+	"f4":          {VK: 0x73, Scan: 0x3E},
+	"f5":          {VK: 0x74, Scan: 0x3F},
+	"f6":          {VK: 0x75, Scan: 0x40},
+	"f7":          {VK: 0x76, Scan: 0x41},
+	"f8":          {VK: 0x77, Scan: 0x42},
+	"f9":          {VK: 0x78, Scan: 0x43},
+	"f10":         {VK: 0x79, Scan: 0x44},
+	"f11":         {VK: 0x7A, Scan: 0x57},
+	"f12":         {VK: 0x7B, Scan: 0x58},
+	"enter":       {VK: 0x0D, Scan: 0x1C},
+	"return":      {VK: 0x0D, Scan: 0x1C},
+	"tab":         {VK: 0x09, Scan: 0x0F},
+	"backspace":   {VK: 0x08, Scan: 0x0E},
+	"space":       {VK: 0x20, Scan: 0x39},
+	"escape":      {VK: 0x1B, Scan: 0x01},
+	"delete":      {VK: 0x2E, Scan: 0xE053},
+	"insert":      {VK: 0x2D, Scan: 0xE052},
+	"home":        {VK: 0x24, Scan: 0xE047},
+	"end":         {VK: 0x23, Scan: 0xE04F},
+	"pageup":      {VK: 0x21, Scan: 0xE049},
+	"pagedown":    {VK: 0x22, Scan: 0xE051},
+	"left":        {VK: 0x25, Scan: 0xE04B},
+	"up":          {VK: 0x26, Scan: 0xE048},
+	"right":       {VK: 0x27, Scan: 0xE04D},
+	"down":        {VK: 0x28, Scan: 0xE050},
+	"!":           {VK: 0x31, Scan: 0x02, Shift: true},
+	"@":           {VK: 0x32, Scan: 0x03, Shift: true},
+	"#":           {VK: 0x33, Scan: 0x04, Shift: true},
+	"$":           {VK: 0x34, Scan: 0x05, Shift: true},
+	"%":           {VK: 0x35, Scan: 0x06, Shift: true},
+	"^":           {VK: 0x36, Scan: 0x07, Shift: true},
+	"&":           {VK: 0x37, Scan: 0x08, Shift: true},
+	"*":           {VK: 0x38, Scan: 0x09, Shift: true},
+	"(":           {VK: 0x39, Scan: 0x0A, Shift: true},
+	")":           {VK: 0x30, Scan: 0x0B, Shift: true},
+	"-":           {VK: 0xBD, Scan: 0x0C},
+	"_":           {VK: 0xBD, Scan: 0x0C, Shift: true},
+	"=":           {VK: 0xBB, Scan: 0x0D},
+	"+":           {VK: 0xBB, Scan: 0x0D, Shift: true},
+	"[":           {VK: 0xDB, Scan: 0x1A},
+	"{":           {VK: 0xDB, Scan: 0x1A, Shift: true},
+	"]":           {VK: 0xDD, Scan: 0x1B},
+	"}":           {VK: 0xDD, Scan: 0x1B, Shift: true},
+	";":           {VK: 0xBA, Scan: 0x27},
+	":":           {VK: 0xBA, Scan: 0x27, Shift: true},
+	"'":           {VK: 0xDE, Scan: 0x28},
+	"\"":          {VK: 0xDE, Scan: 0x28, Shift: true},
+	",":           {VK: 0xBC, Scan: 0x33},
+	"<":           {VK: 0xBC, Scan: 0x33, Shift: true},
+	".":           {VK: 0xBE, Scan: 0x34},
+	">":           {VK: 0xBE, Scan: 0x34, Shift: true},
+	"/":           {VK: 0xBF, Scan: 0x35},
+	"?":           {VK: 0xBF, Scan: 0x35, Shift: true},
+	"`":           {VK: 0xC0, Scan: 0x29},
+	"~":           {VK: 0xC0, Scan: 0x29, Shift: true},
+	"shift":       {VK: 0x10, Scan: 0x2A},
+	"ctrl":        {VK: 0x11, Scan: 0x1D},
+	"control":     {VK: 0x11, Scan: 0x1D},
+	"alt":         {VK: 0x12, Scan: 0x38},
+	"capslock":    {VK: 0x14, Scan: 0x3A},
+	"caps":        {VK: 0x14, Scan: 0x3A},
+	"numlock":     {VK: 0x90, Scan: 0xE045},
+	"scroll":      {VK: 0x91, Scan: 0x46},
+	"printscreen": {VK: 0x2C, Scan: 0xE037},
+	"pause":       {VK: 0x13, Scan: 0x45},
+	"menu":        {VK: 0x5D, Scan: 0xE05D},
+	"super":       {VK: 0x5B, Scan: 0xE05B},
+	"win":         {VK: 0x5B, Scan: 0xE05B},
+	"numpad0":     {VK: 0x60, Scan: 0x52},
+	"numpad1":     {VK: 0x61, Scan: 0x4F},
+	"numpad2":     {VK: 0x62, Scan: 0x50},
+	"numpad3":     {VK: 0x63, Scan: 0x51},
+	"numpad4":     {VK: 0x64, Scan: 0x4B},
+	"numpad5":     {VK: 0x65, Scan: 0x4C},
+	"numpad6":     {VK: 0x66, Scan: 0x4D},
+	"numpad7":     {VK: 0x67, Scan: 0x47},
+	"numpad8":     {VK: 0x68, Scan: 0x48},
+	"numpad9":     {VK: 0x69, Scan: 0x49},
+	"numpad*":     {VK: 0x6A, Scan: 0x37},
+	"numpad+":     {VK: 0x6B, Scan: 0x4E},
+	"numpad-":     {VK: 0x6D, Scan: 0x4A},
+	"numpad.":     {VK: 0x6E, Scan: 0x53},
+	"numpad/":     {VK: 0x6F, Scan: 0xE035},
+}
+
+func TestAllowedKeysResolve(t *testing.T) {
+	seen := map[string]bool{}
+	for group, names := range getAllowedKeys() {
+		for _, name := range names {
+			if seen[name] {
+				t.Errorf("%s: listed twice", name)
+			}
+			seen[name] = true
+			want, ok := wantKeys[name]
+			if !ok {
+				t.Errorf("%s (%s): no expected keystroke", name, group)
+				continue
+			}
+			for _, spelling := range []string{name, strings.ToUpper(name)} {
+				got, ok := lookupKey(spelling)
+				if !ok || got != want {
+					t.Errorf("lookupKey(%q) = %+v, %v; want %+v", spelling, got, ok, want)
+				}
+			}
+			if isModifierKey(name) != (group == "modifiers") {
+				t.Errorf("%s: isModifierKey = %v in group %s", name, isModifierKey(name), group)
+			}
+		}
+	}
+	for name := range wantKeys {
+		if !seen[name] {
+			t.Errorf("%s: expected but not allowed", name)
+		}
+	}
+}
+
+func TestShiftedKeysWrapShift(t *testing.T) {
+	for _, mode := range []string{modeVK, modeScanCode} {
+		for _, name := range getAllowedKeys()["special"] {
+			ks, _ := lookupKey(name)
+			f := useFake(t)
+			s, err := newKeySender(mode, Timing{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.tap(ks, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			var want []KeyEvent
+			if mode == modeVK {
+				want = []KeyEvent{down(ks.VK), up(ks.VK)}
+				if ks.Shift {
+					want = []KeyEvent{down(vkShift), down(ks.VK), up(ks.VK), up(vkShift)}
+				}
+			} else {
+				want = []KeyEvent{{Scan: ks.Scan, Down: true}, {Scan: ks.Scan}}
+				if ks.Shift {
+					want = []KeyEvent{{Scan: keyShift.Scan, Down: true}, want[0], want[1], {Scan: keyShift.Scan}}
+				}
+			}
+			if got := f.Events(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %q: events %v, want %v", mode, name, got, want)
+			}
+			if len(s.held) != 0 {
+				t.Errorf("%s %q: left %v held", mode, name, s.held)
+			}
+		}
+	}
+}
+
+func TestShiftNotWrappedWhenHeld(t *testing.T) {
+	f := useFake(t)
+	s, _ := newKeySender(modeVK, Timing{})
+	ks, _ := lookupKey("?")
+	s.down(keyShift)
+	s.tap(ks, 0)
+	s.releaseAll()
+	want := []KeyEvent{down(vkShift), down(ks.VK), up(ks.VK), up(vkShift)}
+	if got := f.Events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+}
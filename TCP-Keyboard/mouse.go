@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Limits for mouse requests.
+const (
+	dragSteps = 10  // moves between a drag's ends, so it is seen as a drag rather than a jump
+	maxScroll = 100 // wheel notches per request, on each axis
+)
+
+// MouseRequest is a mouse_move, mouse_click, mouse_drag or mouse_scroll
+// request. Without a window target x, y (and to_x, to_y) are screen
+// coordinates; with one, the window is focused as for keypress and they are
+// relative to its client area, wherever the window is at the time.
+type MouseRequest struct {
+	Action string `json:"action"`
+	X      *int32 `json:"x"`
+	Y      *int32 `json:"y"`
+	ToX    *int32 `json:"to_x"` // mouse_drag
+	ToY    *int32 `json:"to_y"`
+	Button string `json:"button"`  // left (default), right or middle
+	Double bool   `json:"double"`  // mouse_click
+	DeltaX int32  `json:"delta_x"` // mouse_scroll, in wheel notches: right
+	DeltaY int32  `json:"delta_y"` // and up; negative for left and down
+	WindowTarget
+	TimingRequest
+	QueueRequest
+}
+
+// mouseButtons are the button names requests use.
+var mouseButtons = map[string]MouseButton{
+	"left":   ButtonLeft,
+	"right":  ButtonRight,
+	"middle": ButtonMiddle,
+}
+
+// hasWindow reports whether the request names a window.
+func (r MouseRequest) hasWindow() bool {
+	return r.WindowTitle != "" || r.Match != nil
+}
+
+// check verifies the request has the fields its action needs and returns
+// the button it uses.
+func (r MouseRequest) check() (MouseButton, error) {
+	if r.hasWindow() {
+		if err := r.WindowTarget.check(); err != nil {
+			return 0, err
+		}
+	}
+	if (r.X == nil) != (r.Y == nil) || (r.ToX == nil) != (r.ToY == nil) {
+		return 0, fmt.Errorf("Give both coordinates of a point, or neither")
+	}
+	switch r.Action {
+	case "mouse_move":
+		if r.X == nil {
+			return 0, fmt.Errorf("mouse_move needs x and y")
+		}
+	case "mouse_drag":
+		if r.X == nil || r.ToX == nil {
+			return 0, fmt.Errorf("mouse_drag needs x, y, to_x and to_y")
+		}
+	case "mouse_scroll":
+		if r.DeltaX == 0 && r.DeltaY == 0 {
+			return 0, fmt.Errorf("mouse_scroll needs delta_x or delta_y")
+		}
+		if r.DeltaX < -maxScroll || r.DeltaX > maxScroll || r.DeltaY < -maxScroll || r.DeltaY > maxScroll {
+			return 0, fmt.Errorf("delta_x and delta_y must be between %d and %d", -maxScroll, maxScroll)
+		}
+	}
+	if r.Button == "" {
+		return ButtonLeft, nil
+	}
+	b, ok := mouseButtons[r.Button]
+	if !ok {
+		return 0, fmt.Errorf("unknown button '%s' (use left, right or middle)", r.Button)
+	}
+	return b, nil
+}
+
+// mouseSender sends the events of one request with its timing, and
+// remembers which buttons it holds so guard can release them.
+type mouseSender struct {
+	timing Timing
+	held   []MouseButton
+}
+
+func (s *mouseSender) move(x, y int32) error {
+	err := backend.Mouse.MoveTo(x, y)
+	time.Sleep(s.timing.KeyGap)
+	return err
+}
+
+func (s *mouseSender) down(b MouseButton) error {
+	s.held = append(s.held, b)
+	err := backend.Mouse.ButtonDown(b)
+	time.Sleep(s.timing.KeyGap)
+	return err
+}
+
+func (s *mouseSender) up(b MouseButton) error {
+	for i, h := range s.held {
+		if h == b {
+			s.held = append(s.held[:i], s.held[i+1:]...)
+			break
+		}
+	}
+	err := backend.Mouse.ButtonUp(b)
+	time.Sleep(s.timing.KeyGap)
+	return err
+}
+
+// click presses b, holds it for the key_down time and releases it.
+func (s *mouseSender) click(b MouseButton) error {
+	s.held = append(s.held, b)
+	if err := backend.Mouse.ButtonDown(b); err != nil {
+		return err
+	}
+	time.Sleep(s.timing.KeyDown)
+	return s.up(b)
+}
+
+// guard is deferred like keySender.guard: it turns a panic into an error
+// response and releases any buttons still held.
+func (s *mouseSender) guard(resp *string) {
+	if r := recover(); r != nil {
+		log.Printf("Panic while sending mouse input: %v\n", r)
+		*resp = toJSON("error", fmt.Sprintf("Internal error: %v", r), nil)
+	}
+	for len(s.held) > 0 {
+		log.Printf("Releasing %s mouse button left held\n", buttonName(s.held[0]))
+		s.up(s.held[0])
+	}
+}
+
+func handleMouse(req MouseRequest, button MouseButton, timing Timing) (resp string) {
+	if backend.Mouse == nil {
+		return toJSON("error", fmt.Sprintf("Mouse input is not supported by the %s backend", backend.Name), nil)
+	}
+
+	// Screen coordinates, unless the request names a window
+	where := "on the screen"
+	toScreen := func(x, y int32) (int32, int32, error) { return x, y, nil }
+	if req.hasWindow() {
+		w, errResp := focusTarget(req.WindowTarget, timing.FocusTimeout)
+		if errResp != "" {
+			return errResp
+		}
+		// Read the client area only now, so a window that has moved still
+		// gets clicked in the right place
+		client, err := backend.Windows.ClientRect(w.Handle)
+		if err != nil {
+			return toJSON("error", fmt.Sprintf("Failed to get the client area of window '%s': %v", windowLabel(req.WindowTarget, w), err), nil)
+		}
+		where = fmt.Sprintf("in window '%s'", windowLabel(req.WindowTarget, w))
+		width, height := client.Right-client.Left, client.Bottom-client.Top
+		toScreen = func(x, y int32) (int32, int32, error) {
+			if x < 0 || y < 0 || x >= width || y >= height {
+				return 0, 0, fmt.Errorf("(%d, %d) is outside the %dx%d client area of the window", x, y, width, height)
+			}
+			return client.Left + x, client.Top + y, nil
+		}
+	}
+
+	var x, y, toX, toY int32
+	var err error
+	if req.X != nil {
+		if x, y, err = toScreen(*req.X, *req.Y); err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+	}
+	if req.ToX != nil {
+		if toX, toY, err = toScreen(*req.ToX, *req.ToY); err != nil {
+			return toJSON("error", err.Error(), nil)
+		}
+	}
+	at := "at the pointer"
+	if req.X != nil {
+		at = fmt.Sprintf("at (%d, %d)", *req.X, *req.Y)
+	}
+
+	s := &mouseSender{timing: timing}
+	defer s.guard(&resp)
+
+	if req.X != nil {
+		if err := s.move(x, y); err != nil {
+			return toJSON("error", "Failed to move the pointer: "+err.Error(), nil)
+		}
+	}
+	var done string
+	switch req.Action {
+	case "mouse_move":
+		done = fmt.Sprintf("Moved the pointer to (%d, %d) %s", *req.X, *req.Y, where)
+
+	case "mouse_click":
+		clicks, verb := 1, "Clicked"
+		if req.Double {
+			clicks, verb = 2, "Double-clicked"
+		}
+		for i := 0; i < clicks; i++ {
+			if err := s.click(button); err != nil {
+				return toJSON("error", fmt.Sprintf("Failed to click %s button: %v", buttonName(button), err), nil)
+			}
+		}
+		done = fmt.Sprintf("%s %s button %s %s", verb, buttonName(button), at, where)
+
+	case "mouse_drag":
+		if err := s.down(button); err != nil {
+			return toJSON("error", fmt.Sprintf("Failed to press %s button: %v", buttonName(button), err), nil)
+		}
+		for i := int64(1); i <= dragSteps; i++ {
+			if err := s.move(lerp(x, toX, i), lerp(y, toY, i)); err != nil {
+				return toJSON("error", "Failed to move the pointer: "+err.Error(), nil)
+			}
+		}
+		if err := s.up(button); err != nil {
+			return toJSON("error", fmt.Sprintf("Failed to release %s button: %v", buttonName(button), err), nil)
+		}
+		done = fmt.Sprintf("Dragged %s button from (%d, %d) to (%d, %d) %s", buttonName(button), *req.X, *req.Y, *req.ToX, *req.ToY, where)
+
+	case "mouse_scroll":
+		if err := backend.Mouse.Scroll(req.DeltaX, req.DeltaY); err != nil {
+			return toJSON("error", "Failed to scroll: "+err.Error(), nil)
+		}
+		time.Sleep(timing.KeyGap)
+		done = fmt.Sprintf("Scrolled %d right, %d up %s %s", req.DeltaX, req.DeltaY, at, where)
+	}
+
+	log.Println(done)
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": done,
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
+
+// lerp returns the point step i of dragSteps along from a to b. It works in
+// int64, where the distance times the step cannot overflow.
+func lerp(a, b int32, i int64) int32 {
+	return int32(int64(a) + (int64(b)-int64(a))*i/dragSteps)
+}
+
+// buttonName returns the name requests use for b.
+func buttonName(b MouseButton) string {
+	for name, mb := range mouseButtons {
+		if mb == b {
+			return name
+		}
+	}
+	return fmt.Sprint(int(b))
+}
@@ -0,0 +1,110 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of start times in /proc, which is 100 on
+// every Linux architecture Go supports.
+const clockTicks = 100
+
+// listProcesses returns every running process, from /proc.
+func listProcesses() ([]Process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []Process
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil {
+			continue // not a process
+		}
+		exe := processExe(uint32(pid))
+		if exe == "" {
+			// Other users' processes and kernel threads hide exe
+			comm, err := os.ReadFile(filepath.Join("/proc", e.Name(), "comm"))
+			if err != nil {
+				continue // exited meanwhile
+			}
+			exe = strings.TrimSpace(string(comm))
+		}
+		procs = append(procs, Process{PID: uint32(pid), Exe: exe})
+	}
+	return procs, nil
+}
+
+// processExe returns the file name of the executable running as pid, or ""
+// where /proc does not tell.
+func processExe(pid uint32) string {
+	if path := processPath(pid); path != "" {
+		return filepath.Base(path)
+	}
+	return ""
+}
+
+// processPath returns the full path of the executable running as pid, or ""
+// where /proc does not tell.
+func processPath(pid uint32) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// samePath reports whether two executable paths name the same file.
+func samePath(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// processStartTime returns when pid was started, so a reused pid can be told
+// apart from the process that had it before. /proc gives it in clock ticks
+// since boot.
+func processStartTime(pid uint32) (time.Time, bool) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, false
+	}
+	// The command name in parentheses may hold spaces; starttime is the
+	// 22nd field, the 20th after it
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, false
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	boot, ok := bootTime()
+	if !ok {
+		return time.Time{}, false
+	}
+	return boot.Add(time.Duration(ticks) * (time.Second / clockTicks)), true
+}
+
+// bootTime returns when the system booted, from the btime line of /proc/stat.
+func bootTime() (time.Time, bool) {
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if rest, ok := strings.CutPrefix(line, "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return time.Unix(secs, 0), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessStartTime(t *testing.T) {
+	pid := uint32(os.Getpid())
+	created, ok := processStartTime(pid)
+	if !ok {
+		t.Fatal("no start time for this process")
+	}
+	if age := time.Since(created); age < 0 || age > time.Hour {
+		t.Errorf("this process started %v ago", age)
+	}
+	again, _ := processStartTime(pid)
+	if !again.Equal(created) {
+		t.Errorf("start time changed from %v to %v", created, again)
+	}
+
+	// A pid now held by another process is not the launched one
+	if l := (&launchedProc{created: created}); !l.owns(pid) {
+		t.Error("launch does not own its own process")
+	}
+	if l := (&launchedProc{created: created.Add(-time.Second)}); l.owns(pid) {
+		t.Error("launch owns a process started after it")
+	}
+	if l := (&launchedProc{created: created, exited: true}); l.owns(pid) {
+		t.Error("exited launch owns a running process")
+	}
+}
+
+func TestPruneLaunched(t *testing.T) {
+	launchedMu.Lock()
+	defer launchedMu.Unlock()
+	saved := launched
+	defer func() { launched = saved }()
+
+	now := time.Now()
+	launched = map[uint32]*launchedProc{
+		1: {},
+		2: {exited: true, exitedAt: now.Add(-2 * exitedRetention)},
+	}
+	for i := 0; i < maxExited+5; i++ {
+		launched[uint32(100+i)] = &launchedProc{exited: true, exitedAt: now.Add(time.Duration(i) * time.Second)}
+	}
+	pruneLaunched()
+
+	if launched[1] == nil {
+		t.Error("running launch was forgotten")
+	}
+	if launched[2] != nil {
+		t.Error("expired launch was kept")
+	}
+	if len(launched) != 1+maxExited {
+		t.Errorf("%d launches kept, want %d", len(launched), 1+maxExited)
+	}
+	for i := 0; i < 5; i++ {
+		if launched[uint32(100+i)] != nil {
+			t.Errorf("launch %d is one of the oldest but was kept", 100+i)
+		}
+	}
+}
+
+func TestAppForProcess(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := config.Apps
+	defer func() { config.Apps = saved }()
+	self := Process{PID: uint32(os.Getpid()), Exe: filepath.Base(exe)}
+
+	config.Apps = map[string]AppConfig{"test": {Path: exe}}
+	if id, ok := appForProcess(self); !ok || id != "test" {
+		t.Errorf("got %q, %v for the configured path", id, ok)
+	}
+
+	// The same file name elsewhere is another program
+	config.Apps = map[string]AppConfig{"other": {Path: filepath.Join(t.TempDir(), filepath.Base(exe))}}
+	if id, ok := appForProcess(self); ok {
+		t.Errorf("process taken for app %q by its file name alone", id)
+	}
+}
+
+func TestLaunchWorkingDir(t *testing.T) {
+	saved := config.Apps
+	defer func() { config.Apps = saved }()
+	allowed := t.TempDir()
+	config.Apps = map[string]AppConfig{"sim": {Path: filepath.Join(allowed, "sim"), WorkingDirs: []string{allowed}}}
+
+	for _, dir := range []string{t.TempDir(), "relative", filepath.Join(allowed, "..")} {
+		resp := call(t, `{"action":"launch_app","app":"sim","working_dir":`+strconv.Quote(dir)+`}`)
+		wantStatus(t, resp, "error")
+		if msg, _ := resp["message"].(string); !strings.Contains(msg, "working_dir") {
+			t.Errorf("%s: %s", dir, msg)
+		}
+	}
+	if !config.Apps["sim"].allowsDir(allowed + string(filepath.Separator)) {
+		t.Error("configured working dir not allowed")
+	}
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// listProcesses returns every running process, from a Toolhelp snapshot.
+func listProcesses() ([]Process, error) {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(snap)
+
+	var procs []Process
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = syscall.Process32First(snap, &entry); err == nil; err = syscall.Process32Next(snap, &entry) {
+		procs = append(procs, Process{
+			PID: entry.ProcessID,
+			Exe: syscall.UTF16ToString(entry.ExeFile[:]),
+		})
+	}
+	if err != syscall.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+	return procs, nil
+}
+
+// processStartTime returns when pid was created, so a reused pid can be told
+// apart from the process that had it before.
+func processStartTime(pid uint32) (time.Time, bool) {
+	p, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer syscall.CloseHandle(p)
+	var created, exited, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(p, &created, &exited, &kernel, &user); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, created.Nanoseconds()), true
+}
+
+// samePath reports whether two executable paths name the same file. Windows
+// paths ignore case.
+func samePath(a, b string) bool {
+	return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+}
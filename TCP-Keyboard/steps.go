@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeyStep is one entry of a keypress "keys" array. A plain string is a Key:
+// a single key name, or a combo such as "ctrl+shift+s" whose modifiers are
+// held for that chord only. The object forms {"down":"shift"} and
+// {"up":"shift"} press or release a key on its own, and {"key":...,
+// "hold_ms":N} holds a key or chord down for N milliseconds, then releases
+// it; this applies to a lone modifier too.
+type KeyStep struct {
+	Key    string `json:"key,omitempty"`
+	Down   string `json:"down,omitempty"`
+	Up     string `json:"up,omitempty"`
+	HoldMs int    `json:"hold_ms,omitempty"`
+}
+
+func (s *KeyStep) UnmarshalJSON(b []byte) error {
+	var key string
+	if err := json.Unmarshal(b, &key); err == nil {
+		*s = KeyStep{Key: key}
+		return nil
+	}
+	type plain KeyStep // without this method
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return fmt.Errorf("a key step must be a key name or an object with key, down or up")
+	}
+	*s = KeyStep(p)
+	return nil
+}
+
+// String returns the step as it is reported back: the key or combo itself,
+// or "down:<key>" / "up:<key>".
+func (s KeyStep) String() string {
+	switch {
+	case s.Down != "":
+		return "down:" + s.Down
+	case s.Up != "":
+		return "up:" + s.Up
+	}
+	return s.Key
+}
+
+// check verifies the step has exactly one of key, down and up, and that
+// hold_ms is only given with key.
+func (s KeyStep) check() error {
+	n := 0
+	for _, v := range []string{s.Key, s.Down, s.Up} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("a key step needs exactly one of key, down or up")
+	}
+	if s.HoldMs < 0 || (s.HoldMs > 0 && s.Key == "") {
+		return fmt.Errorf("hold_ms must be positive and is only allowed with key")
+	}
+	return nil
+}
+
+// Chord is a key combo: modifiers held while the final key is tapped.
+type Chord struct {
+	Modifiers []Keystroke
+	Key       Keystroke
+}
+
+// parseChord resolves a key name or combo such as "ctrl+shift+s". Every part
+// but the last must be a modifier. A trailing "+" belongs to the last key,
+// so "ctrl++" is ctrl with plus and "ctrl+numpad+" is ctrl with numpad+.
+func parseChord(combo string) (Chord, error) {
+	if ks, ok := lookupKey(combo); ok {
+		return Chord{Key: ks}, nil
+	}
+	parts := strings.Split(combo, "+")
+	if n := len(parts); n > 1 && parts[n-1] == "" {
+		parts[n-2] += "+"
+		parts = parts[:n-1]
+	}
+
+	var c Chord
+	for i, part := range parts {
+		ks, ok := lookupKey(part)
+		if !ok {
+			return Chord{}, fmt.Errorf("Unknown key: %s", part)
+		}
+		if i == len(parts)-1 {
+			c.Key = ks
+		} else if !isModifierKey(strings.ToLower(part)) {
+			return Chord{}, fmt.Errorf("'%s' in '%s' is not a modifier", part, combo)
+		} else {
+			c.Modifiers = append(c.Modifiers, ks)
+		}
+	}
+	return c, nil
+}
+
+// Kinds of planned step.
+const (
+	stepTap  = iota // tap a key or combo
+	stepDown        // press and hold a key
+	stepUp          // release a key
+)
+
+// plannedStep is a KeyStep resolved and checked, ready to inject.
+type plannedStep struct {
+	kind  int
+	label string
+	key   Keystroke // for stepDown and stepUp
+	chord Chord     // for stepTap
+	hold  time.Duration
+}
+
+// planSteps resolves every step of a keys array before anything is
+// injected, so a bad entry late in the array cannot leave earlier keys held
+// down. Errors name the offending entry.
+func planSteps(steps []KeyStep, timing Timing) ([]plannedStep, error) {
+	plan := make([]plannedStep, 0, len(steps))
+	for i, step := range steps {
+		p, err := planStep(step, timing)
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %v", i, err)
+		}
+		plan = append(plan, p)
+	}
+	return plan, nil
+}
+
+func planStep(step KeyStep, timing Timing) (plannedStep, error) {
+	if err := step.check(); err != nil {
+		return plannedStep{}, err
+	}
+	p := plannedStep{label: step.String()}
+
+	switch {
+	case step.Down != "" || step.Up != "":
+		// Explicit press, held until its up step or the end
+		name := step.Down + step.Up
+		ks, ok := lookupKey(name)
+		if !ok {
+			return plannedStep{}, fmt.Errorf("Unknown key: %s", name)
+		}
+		p.kind, p.key = stepDown, ks
+		if step.Up != "" {
+			p.kind = stepUp
+		}
+
+	case isModifierKey(strings.ToLower(step.Key)) && step.HoldMs == 0:
+		// A plain modifier is held until the end; with hold_ms it is
+		// tapped like any other key
+		p.kind = stepDown
+		p.key, _ = lookupKey(step.Key)
+
+	default:
+		chord, err := parseChord(step.Key)
+		if err != nil {
+			return plannedStep{}, err
+		}
+		p.kind, p.chord, p.hold = stepTap, chord, timing.KeyDown
+		if step.HoldMs > 0 {
+			if step.HoldMs > config.Timing.KeyDownMs.Max {
+				return plannedStep{}, fmt.Errorf("hold_ms must be at most %d", config.Timing.KeyDownMs.Max)
+			}
+			p.hold = time.Duration(step.HoldMs) * time.Millisecond
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Limits for wait_for_window.
+const (
+	defaultWindowWait = 30 * time.Second
+	maxWindowWait     = 5 * time.Minute
+	windowPoll        = 100 * time.Millisecond
+)
+
+// Conditions wait_for_window can wait for.
+const (
+	waitAppear      = "appear"       // a matching window exists (the default)
+	waitDisappear   = "disappear"    // no matching window exists
+	waitForeground  = "foreground"   // a matching window has focus
+	waitTitleChange = "title_change" // the matching window's title is no longer what it was
+)
+
+// waitGoals say what each condition waits for, in timeout messages.
+var waitGoals = map[string]string{
+	waitAppear:      "appear",
+	waitDisappear:   "disappear",
+	waitForeground:  "come to the foreground",
+	waitTitleChange: "change title",
+}
+
+type WaitForWindowRequest struct {
+	Action        string `json:"action"`
+	Condition     string `json:"condition"`
+	WaitTimeoutMs int    `json:"wait_timeout_ms"`
+	WindowTarget
+}
+
+// timeout checks the request's condition and returns how long to wait.
+func (r WaitForWindowRequest) timeout() (time.Duration, error) {
+	if _, ok := waitGoals[r.Condition]; !ok && r.Condition != "" {
+		return 0, fmt.Errorf("unknown condition '%s' (use appear, disappear, foreground or title_change)", r.Condition)
+	}
+	wait := defaultWindowWait
+	if r.WaitTimeoutMs > 0 {
+		wait = time.Duration(r.WaitTimeoutMs) * time.Millisecond
+	}
+	if r.WaitTimeoutMs < 0 || wait > maxWindowWait {
+		return 0, fmt.Errorf("wait_timeout_ms must be between 0 and %d", maxWindowWait.Milliseconds())
+	}
+	return wait, nil
+}
+
+// handleWaitForWindow polls the window list until the condition holds and
+// returns the window it holds for: the one that appeared, has focus or was
+// retitled, or the last one seen before they all disappeared.
+func handleWaitForWindow(req WaitForWindowRequest, timeout time.Duration) string {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	condition := req.Condition
+	if condition == "" {
+		condition = waitAppear
+	}
+
+	// A title change is watched on the one window that matches now
+	var watched Window
+	if condition == waitTitleChange {
+		w, errResp := findWindow(req.WindowTarget)
+		if errResp != "" {
+			return errResp
+		}
+		watched = w
+	}
+
+	var last *Window // the last matching window seen
+	for {
+		windows, err := backend.Windows.Windows()
+		if err != nil {
+			return toJSON("error", "Failed to list windows: "+err.Error(), nil)
+		}
+
+		var found *Window
+		switch condition {
+		case waitAppear, waitDisappear:
+			for i := range windows {
+				if req.matches(windows[i]) {
+					found = &windows[i]
+					break
+				}
+			}
+			if found != nil {
+				last = found
+			}
+			if condition == waitDisappear && found == nil {
+				return waitResult(fmt.Sprintf("Window '%s' disappeared", req.WindowTarget), last, start)
+			}
+		case waitForeground:
+			fg := backend.Windows.Foreground()
+			for i := range windows {
+				if windows[i].Handle == fg && req.matches(windows[i]) {
+					found = &windows[i]
+				}
+			}
+		case waitTitleChange:
+			now, ok := windowByHandle(watched.Handle)
+			if !ok {
+				return toJSON("error", fmt.Sprintf("Window '%s' closed before its title changed", watched.Title),
+					map[string]interface{}{"window": watched})
+			}
+			if now.Title != watched.Title {
+				resp := waitResult(fmt.Sprintf("Window '%s' is now titled '%s'", watched.Title, now.Title), &now, start)
+				return withFields(resp, map[string]interface{}{"previous_title": watched.Title})
+			}
+		}
+		if found != nil && condition != waitDisappear {
+			verb := "appeared"
+			if condition == waitForeground {
+				verb = "has focus"
+			}
+			return waitResult(fmt.Sprintf("Window '%s' %s", found.Title, verb), found, start)
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("wait_for_window timed out: %s '%s'\n", condition, req.WindowTarget)
+			data := map[string]interface{}{"waited_ms": time.Since(start).Milliseconds()}
+			if last != nil {
+				data["window"] = *last
+			}
+			return toJSON("error", fmt.Sprintf("Timed out after %d ms waiting for '%s' to %s", timeout.Milliseconds(), req.WindowTarget, waitGoals[condition]), data)
+		}
+		time.Sleep(windowPoll)
+	}
+}
+
+// waitResult is the success response of wait_for_window.
+func waitResult(message string, w *Window, start time.Time) string {
+	log.Println(message)
+	response := map[string]interface{}{
+		"status":    "success",
+		"message":   message,
+		"window":    w,
+		"waited_ms": time.Since(start).Milliseconds(),
+	}
+	jsonResp, _ := json.Marshal(response)
+	return string(jsonResp)
+}
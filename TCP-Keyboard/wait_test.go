@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// later runs change once the wait has had time to poll at least once.
+func later(change func()) {
+	go func() {
+		time.Sleep(2 * windowPoll)
+		change()
+	}()
+}
+
+// waitedFor returns the title of the window in a wait_for_window response.
+func waitedFor(t *testing.T, resp map[string]interface{}) string {
+	t.Helper()
+	w, ok := resp["window"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no window in %v", resp)
+	}
+	title, _ := w["title"].(string)
+	return title
+}
+
+func TestWaitAppear(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Untitled - Notepad", true)
+	later(func() { f.AddWindow("Simulator - Loading", true) })
+
+	resp := call(t, `{"action":"wait_for_window","window_title":"simulator","wait_timeout_ms":5000}`)
+	wantStatus(t, resp, "success")
+	if got := waitedFor(t, resp); got != "Simulator - Loading" {
+		t.Errorf("window %q", got)
+	}
+	if ms := resp["waited_ms"].(float64); ms < float64(windowPoll.Milliseconds()) {
+		t.Errorf("waited %v ms for a window that came later", ms)
+	}
+}
+
+func TestWaitDisappear(t *testing.T) {
+	f := useFake(t)
+	h := f.AddWindow("Save As", true)
+	later(func() { f.Close(h) })
+
+	resp := call(t, `{"action":"wait_for_window","window_title":"save as","condition":"disappear","wait_timeout_ms":5000}`)
+	wantStatus(t, resp, "success")
+	if got := waitedFor(t, resp); got != "Save As" {
+		t.Errorf("last window seen %q", got)
+	}
+}
+
+func TestWaitForeground(t *testing.T) {
+	f := useFake(t)
+	h := f.AddWindow("Simulator", true)
+	f.AddWindow("Untitled - Notepad", true) // on top
+	later(func() { f.SetForeground(h) })
+
+	resp := call(t, `{"action":"wait_for_window","window_title":"simulator","condition":"foreground","wait_timeout_ms":5000}`)
+	wantStatus(t, resp, "success")
+	if got := waitedFor(t, resp); got != "Simulator" {
+		t.Errorf("window %q", got)
+	}
+}
+
+func TestWaitTitleChange(t *testing.T) {
+	f := useFake(t)
+	h := f.AddWindow("Simulator - Loading", true)
+	later(func() { f.update(h, func(w *Window) { w.Title = "Simulator - Ready" }) })
+
+	resp := call(t, `{"action":"wait_for_window","window_title":"simulator","condition":"title_change","wait_timeout_ms":5000}`)
+	wantStatus(t, resp, "success")
+	if got := waitedFor(t, resp); got != "Simulator - Ready" {
+		t.Errorf("window %q", got)
+	}
+	if resp["previous_title"] != "Simulator - Loading" {
+		t.Errorf("previous title %v", resp["previous_title"])
+	}
+
+	// The window closing ends the wait at once
+	later(func() { f.Close(h) })
+	resp = call(t, `{"action":"wait_for_window","window_title":"simulator","condition":"title_change","wait_timeout_ms":5000}`)
+	wantStatus(t, resp, "error")
+	if msg := resp["message"].(string); !strings.Contains(msg, "closed before its title changed") {
+		t.Errorf("message %q", msg)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	f := useFake(t)
+	f.AddWindow("Simulator", true)
+
+	cases := []struct {
+		request string
+		message string
+		window  bool
+	}{
+		{`"window_title":"save as"`, "Timed out after 150 ms waiting for 'save as' to appear", false},
+		{`"window_title":"simulator","condition":"disappear"`, "Timed out after 150 ms waiting for 'simulator' to disappear", true},
+		{`"window_title":"notepad","condition":"foreground"`, "Timed out after 150 ms waiting for 'notepad' to come to the foreground", false},
+	}
+	for _, c := range cases {
+		resp := call(t, `{"action":"wait_for_window",`+c.request+`,"wait_timeout_ms":150}`)
+		wantStatus(t, resp, "error")
+		if resp["message"] != c.message {
+			t.Errorf("message %q, want %q", resp["message"], c.message)
+		}
+		data, _ := resp["data"].(map[string]interface{})
+		if ms, _ := data["waited_ms"].(float64); ms < 150 {
+			t.Errorf("%s: gave up after %v ms", c.request, ms)
+		}
+		if _, ok := data["window"]; ok != c.window {
+			t.Errorf("%s: window in data %v, want %v", c.request, ok, c.window)
+		}
+	}
+}
+
+func TestWaitBadRequest(t *testing.T) {
+	useFake(t)
+	for request, want := range map[string]string{
+		`"window_title":"x","condition":"blink"`:      "unknown condition 'blink'",
+		`"window_title":"x","wait_timeout_ms":-1`:     "wait_timeout_ms must be between 0 and 300000",
+		`"window_title":"x","wait_timeout_ms":300001`: "wait_timeout_ms must be between 0 and 300000",
+		`"condition":"appear"`:                        "Missing window_title or match field",
+	} {
+		resp := call(t, `{"action":"wait_for_window",`+request+`}`)
+		wantStatus(t, resp, "error")
+		if msg, _ := resp["message"].(string); !strings.Contains(msg, want) {
+			t.Errorf("%s: message %q, want %q", request, msg, want)
+		}
+	}
+}
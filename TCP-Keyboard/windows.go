@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WindowFilter selects windows for the listing actions. Empty fields match
+// everything.
+type WindowFilter struct {
+	VisibleOnly bool   `json:"visible_only"`
+	HasTitle    bool   `json:"has_title"`
+	Process     string `json:"process"` // executable name, ".exe" optional, case-insensitive
+	Class       string `json:"class"`   // window class, case-insensitive
+}
+
+func (f WindowFilter) Match(w Window) bool {
+	switch {
+	case f.VisibleOnly && !w.Visible:
+		return false
+	case f.HasTitle && w.Title == "":
+		return false
+	case f.Process != "" && !sameExe(w.Exe, f.Process):
+		return false
+	case f.Class != "" && !strings.EqualFold(w.Class, f.Class):
+		return false
+	}
+	return true
+}
+
+// sameExe reports whether exe is the executable called name, ignoring case
+// and an ".exe" extension on either.
+func sameExe(exe, name string) bool {
+	trim := func(s string) string {
+		s = strings.ToLower(s)
+		return strings.TrimSuffix(s, ".exe")
+	}
+	return exe != "" && trim(exe) == trim(name)
+}
+
+// Ambiguity policies for a WindowMatch that fits more than one window.
+const (
+	ambiguityFail         = "fail"                 // report the candidates (the default)
+	ambiguityFirst        = "first"                // take the front-most in z-order
+	ambiguityMostRecently = "most_recently_active" // take the one active last (see mostRecentlyActive)
+)
+
+// WindowMatch picks a window by any combination of its properties; all the
+// given ones must fit. Title and TitlePrefix compare exactly, TitleRegex is
+// a Go regular expression ((?i) for case-insensitive), and Process and
+// Class compare as in WindowFilter.
+type WindowMatch struct {
+	Title       string       `json:"title,omitempty"`
+	TitlePrefix string       `json:"title_prefix,omitempty"`
+	TitleRegex  string       `json:"title_regex,omitempty"`
+	Process     string       `json:"process,omitempty"`
+	Class       string       `json:"class,omitempty"`
+	Handle      WindowHandle `json:"handle,omitempty"`
+	Ambiguity   string       `json:"ambiguity,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile checks the match and compiles its regex.
+func (m *WindowMatch) compile() error {
+	if m.Title == "" && m.TitlePrefix == "" && m.TitleRegex == "" && m.Process == "" && m.Class == "" && m.Handle == 0 {
+		return fmt.Errorf("match needs at least one of title, title_prefix, title_regex, process, class or handle")
+	}
+	switch m.Ambiguity {
+	case "", ambiguityFail, ambiguityFirst, ambiguityMostRecently:
+	default:
+		return fmt.Errorf("unknown ambiguity '%s' (use fail, first or most_recently_active)", m.Ambiguity)
+	}
+	if m.TitleRegex != "" {
+		re, err := regexp.Compile(m.TitleRegex)
+		if err != nil {
+			return fmt.Errorf("invalid title_regex: %v", err)
+		}
+		m.re = re
+	}
+	return nil
+}
+
+func (m *WindowMatch) Match(w Window) bool {
+	switch {
+	case m.Handle != 0 && w.Handle != m.Handle:
+		return false
+	case m.Title != "" && w.Title != m.Title:
+		return false
+	case m.TitlePrefix != "" && !strings.HasPrefix(w.Title, m.TitlePrefix):
+		return false
+	case m.re != nil && !m.re.MatchString(w.Title):
+		return false
+	case m.Process != "" && !sameExe(w.Exe, m.Process):
+		return false
+	case m.Class != "" && !strings.EqualFold(w.Class, m.Class):
+		return false
+	}
+	return true
+}
+
+// String describes the match for messages.
+func (m *WindowMatch) String() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// WindowTarget is how a request names its window: window_title, the first
+// window whose title contains it (case-insensitive), or a match object.
+type WindowTarget struct {
+	WindowTitle string       `json:"window_title"`
+	Match       *WindowMatch `json:"match"`
+}
+
+// check verifies exactly one way of naming the window is used.
+func (t WindowTarget) check() error {
+	switch {
+	case t.WindowTitle == "" && t.Match == nil:
+		return fmt.Errorf("Missing window_title or match field")
+	case t.WindowTitle != "" && t.Match != nil:
+		return fmt.Errorf("Give window_title or match, not both")
+	case t.Match != nil:
+		return t.Match.compile()
+	}
+	return nil
+}
+
+// String describes the target for messages.
+func (t WindowTarget) String() string {
+	if t.Match != nil {
+		return t.Match.String()
+	}
+	return t.WindowTitle
+}
+
+// matches reports whether w is one of the windows t names.
+func (t WindowTarget) matches(w Window) bool {
+	if t.Match != nil {
+		return t.Match.Match(w)
+	}
+	return strings.Contains(strings.ToLower(w.Title), strings.ToLower(t.WindowTitle))
+}
+
+// findWindow resolves a target. If that fails it returns the error
+// response to send instead.
+func findWindow(t WindowTarget) (Window, string) {
+	windows, err := backend.Windows.Windows()
+	if err != nil {
+		return Window{}, toJSON("error", "Failed to list windows: "+err.Error(), nil)
+	}
+	forgetClosed(windows)
+
+	var allWindows []string
+	var candidates []Window
+	for _, w := range windows {
+		if w.Title != "" {
+			allWindows = append(allWindows, w.Title)
+		}
+		if !t.matches(w) {
+			continue
+		}
+		if t.Match == nil {
+			return w, "" // Stop on first match
+		}
+		candidates = append(candidates, w)
+	}
+
+	if len(candidates) == 0 {
+		errorData := map[string]interface{}{
+			"searched_for":      t.String(),
+			"available_windows": allWindows,
+		}
+		log.Printf("Window not found: '%s'. Available windows: %v\n", t, allWindows)
+		return Window{}, toJSON("error", fmt.Sprintf("Window not found: '%s'. Use 'list_windows' action to see available windows.", t), errorData)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], ""
+	}
+
+	switch t.Match.Ambiguity {
+	case ambiguityFirst:
+		return candidates[0], ""
+	case ambiguityMostRecently:
+		return mostRecentlyActive(candidates), ""
+	}
+	log.Printf("Ambiguous window match %s: %d candidates\n", t, len(candidates))
+	return Window{}, toJSON("error", fmt.Sprintf("%d windows match %s; narrow the match or set ambiguity", len(candidates), t),
+		map[string]interface{}{"candidates": candidates})
+}
+
+// windowByHandle looks a window up again, to see it as it is now.
+func windowByHandle(h WindowHandle) (Window, bool) {
+	windows, err := backend.Windows.Windows()
+	if err != nil {
+		return Window{}, false
+	}
+	for _, w := range windows {
+		if w.Handle == h {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// activated records when this server last brought each window to the
+// foreground. Only minimized windows need it; see mostRecentlyActive.
+var (
+	activatedMu sync.Mutex
+	activated   = map[WindowHandle]time.Time{}
+)
+
+func noteActivated(h WindowHandle) {
+	activatedMu.Lock()
+	defer activatedMu.Unlock()
+	activated[h] = time.Now()
+}
+
+// forgetClosed drops the activation records of windows no longer in the
+// list, so the map does not grow and a reused handle starts afresh.
+func forgetClosed(windows []Window) {
+	open := make(map[WindowHandle]bool, len(windows))
+	for _, w := range windows {
+		open[w.Handle] = true
+	}
+	activatedMu.Lock()
+	defer activatedMu.Unlock()
+	for h := range activated {
+		if !open[h] {
+			delete(activated, h)
+		}
+	}
+}
+
+// mostRecentlyActive returns the candidate that was active last, by anyone.
+// Activating a window raises it, so among windows that are not minimized
+// that is the front-most in z-order, the same one first takes. Minimizing
+// sends a window to the back, so if every candidate is minimized it is the
+// one this server focused last, or the front-most if it focused none of
+// them. Candidates are in z-order.
+func mostRecentlyActive(candidates []Window) Window {
+	for _, w := range candidates {
+		if !w.Minimized {
+			return w
+		}
+	}
+	activatedMu.Lock()
+	defer activatedMu.Unlock()
+	best := candidates[0]
+	var bestAt time.Time
+	for _, w := range candidates {
+		if at, ok := activated[w.Handle]; ok && at.After(bestAt) {
+			best, bestAt = w, at
+		}
+	}
+	return best
+}
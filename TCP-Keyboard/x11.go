@@ -0,0 +1,604 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// x11Conn is a minimal X11 protocol client: just the core requests and the
+// XTEST extension the X11 backend needs. It speaks the wire protocol
+// directly so no Xlib or cgo is required.
+type x11Conn struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	seq  uint16
+
+	root        uint32
+	idBase      uint32 // first of the resource IDs this client may allocate
+	minKeycode  byte
+	maxKeycode  byte
+	xtestOpcode byte
+	atoms       map[string]uint32
+}
+
+// Core protocol opcodes and constants used below.
+const (
+	x11GetWindowAttributes   = 3
+	x11MapWindow             = 8
+	x11ConfigureWindow       = 12
+	x11GetGeometry           = 14
+	x11QueryTree             = 15
+	x11InternAtom            = 16
+	x11GetProperty           = 20
+	x11SendEvent             = 25
+	x11TranslateCoordinates  = 40
+	x11SetInputFocus         = 42
+	x11GetInputFocus         = 43
+	x11QueryExtension        = 98
+	x11ChangeKeyboardMapping = 100
+	x11GetKeyboardMapping    = 101
+
+	xtestFakeInput = 2
+
+	x11AtomWMName  = 39
+	x11AtomWMClass = 67
+
+	x11KeyPress       = 2
+	x11KeyRelease     = 3
+	x11ButtonPress    = 4
+	x11ButtonRelease  = 5
+	x11MotionNotify   = 6
+	x11ClientMessage  = 33
+	x11MapStateViewed = 2
+)
+
+var x11Order = binary.LittleEndian
+
+// x11Error is an error packet returned by the server.
+type x11Error struct {
+	Code   byte
+	Opcode byte
+}
+
+func (e x11Error) Error() string {
+	return fmt.Sprintf("X11 error %d for request %d", e.Code, e.Opcode)
+}
+
+// dialX11 connects to the display named by display, in $DISPLAY syntax.
+func dialX11(display string) (*x11Conn, error) {
+	network, addr, number, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to X display %s: %w", display, err)
+	}
+
+	x := &x11Conn{conn: conn, r: bufio.NewReader(conn), atoms: map[string]uint32{}}
+	authName, authData := readXauthority(number)
+	if err := x.setup(authName, authData); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	op, ok, err := x.queryExtension("XTEST")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, errors.New("X server does not support the XTEST extension")
+	}
+	x.xtestOpcode = op
+	return x, nil
+}
+
+// parseDisplay splits "[host]:display[.screen]" into a dial address and the
+// display number used to look up credentials.
+func parseDisplay(display string) (network, addr, number string, err error) {
+	if display == "" {
+		return "", "", "", errors.New("DISPLAY is not set")
+	}
+	i := strings.LastIndexByte(display, ':')
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid DISPLAY %q", display)
+	}
+	host, rest := display[:i], display[i+1:]
+	number = rest
+	if j := strings.IndexByte(rest, '.'); j >= 0 {
+		number = rest[:j]
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid DISPLAY %q", display)
+	}
+
+	switch {
+	case strings.HasPrefix(host, "/"):
+		// A socket path, as XQuartz uses
+		return "unix", host + ":" + number, number, nil
+	case host == "" || host == "unix":
+		return "unix", "/tmp/.X11-unix/X" + number, number, nil
+	}
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), number, nil
+}
+
+// readXauthority returns the MIT-MAGIC-COOKIE-1 for display number from
+// $XAUTHORITY or ~/.Xauthority, or nothing if there is none.
+func readXauthority(number string) (string, []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	hostname, _ := os.Hostname()
+
+	// Entries are family(2) address number name data, each field but the
+	// first a big-endian length followed by bytes
+	field := func() ([]byte, bool) {
+		if len(b) < 2 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			return nil, false
+		}
+		f := b[2 : 2+n]
+		b = b[2+n:]
+		return f, true
+	}
+	// Prefer a cookie for this host, else take any for this display
+	const familyLocal, familyWild = 256, 65535
+	var fallbackName string
+	var fallbackData []byte
+	for len(b) >= 2 {
+		family := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		addr, ok1 := field()
+		num, ok2 := field()
+		name, ok3 := field()
+		data, ok4 := field()
+		if !(ok1 && ok2 && ok3 && ok4) {
+			break
+		}
+		if string(name) != "MIT-MAGIC-COOKIE-1" {
+			continue
+		}
+		if len(num) > 0 && string(num) != number {
+			continue
+		}
+		if family == familyWild || (family == familyLocal && string(addr) == hostname) {
+			return string(name), data
+		}
+		if fallbackName == "" {
+			fallbackName, fallbackData = string(name), data
+		}
+	}
+	return fallbackName, fallbackData
+}
+
+func pad4(n int) int { return (4 - n%4) % 4 }
+
+// setup performs the connection handshake and records the resource ID base,
+// and the root window and keycode range of the first screen.
+func (x *x11Conn) setup(authName string, authData []byte) error {
+	req := make([]byte, 12, 12+len(authName)+len(authData)+8)
+	req[0] = 'l' // little-endian
+	x11Order.PutUint16(req[2:], 11)
+	x11Order.PutUint16(req[6:], uint16(len(authName)))
+	x11Order.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad4(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad4(len(authData)))...)
+	if _, err := x.conn.Write(req); err != nil {
+		return err
+	}
+
+	var hdr [8]byte
+	if _, err := io.ReadFull(x.r, hdr[:]); err != nil {
+		return fmt.Errorf("X11 setup: %w", err)
+	}
+	body := make([]byte, int(x11Order.Uint16(hdr[6:]))*4)
+	if _, err := io.ReadFull(x.r, body); err != nil {
+		return fmt.Errorf("X11 setup: %w", err)
+	}
+	switch hdr[0] {
+	case 0:
+		reason := body
+		if n := int(hdr[1]); n <= len(reason) {
+			reason = reason[:n]
+		}
+		return fmt.Errorf("X11 connection refused: %s", reason)
+	case 2:
+		return errors.New("X11 connection needs further authentication")
+	}
+
+	if len(body) < 32 {
+		return errors.New("X11 setup reply too short")
+	}
+	x.idBase = x11Order.Uint32(body[4:])
+	vendorLen := int(x11Order.Uint16(body[16:]))
+	numFormats := int(body[21])
+	x.minKeycode, x.maxKeycode = body[26], body[27]
+	screen := 32 + vendorLen + pad4(vendorLen) + 8*numFormats
+	if len(body) < screen+4 {
+		return errors.New("X11 setup reply has no screens")
+	}
+	x.root = x11Order.Uint32(body[screen:])
+	return nil
+}
+
+// send writes one request; body must already be padded to 4 bytes. x.mu
+// must be held.
+func (x *x11Conn) send(opcode, data byte, body []byte) (uint16, error) {
+	req := make([]byte, 4, 4+len(body))
+	req[0] = opcode
+	req[1] = data
+	x11Order.PutUint16(req[2:], uint16((4+len(body))/4))
+	req = append(req, body...)
+	if _, err := x.conn.Write(req); err != nil {
+		return 0, err
+	}
+	x.seq++
+	return x.seq, nil
+}
+
+// reply reads packets until the reply or error for seq arrives. Events and
+// errors belonging to earlier requests are skipped. x.mu must be held.
+func (x *x11Conn) reply(seq uint16) ([]byte, error) {
+	for {
+		var hdr [32]byte
+		if _, err := io.ReadFull(x.r, hdr[:]); err != nil {
+			return nil, err
+		}
+		pktSeq := x11Order.Uint16(hdr[2:])
+		switch hdr[0] {
+		case 0:
+			err := x11Error{Code: hdr[1], Opcode: hdr[10]}
+			if pktSeq == seq {
+				return nil, err
+			}
+			log.Printf("X11: %v (ignored)\n", err)
+		case 1, 35: // reply, or a GenericEvent which is also length-prefixed
+			extra := make([]byte, int(x11Order.Uint32(hdr[4:]))*4)
+			if _, err := io.ReadFull(x.r, extra); err != nil {
+				return nil, err
+			}
+			if hdr[0] == 1 && pktSeq == seq {
+				return append(hdr[:], extra...), nil
+			}
+		}
+	}
+}
+
+// call sends a request that has a reply and waits for it.
+func (x *x11Conn) call(opcode, data byte, body []byte) ([]byte, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	seq, err := x.send(opcode, data, body)
+	if err != nil {
+		return nil, err
+	}
+	return x.reply(seq)
+}
+
+// do sends a request that has no reply.
+func (x *x11Conn) do(opcode, data byte, body []byte) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, err := x.send(opcode, data, body)
+	return err
+}
+
+func x11String(s string) []byte {
+	b := make([]byte, 4+len(s)+pad4(len(s)))
+	x11Order.PutUint16(b, uint16(len(s)))
+	copy(b[4:], s)
+	return b
+}
+
+func (x *x11Conn) queryExtension(name string) (byte, bool, error) {
+	rep, err := x.call(x11QueryExtension, 0, x11String(name))
+	if err != nil {
+		return 0, false, err
+	}
+	return rep[9], rep[8] != 0, nil
+}
+
+// atom returns the atom for name, or 0 if it has never been interned.
+func (x *x11Conn) atom(name string) (uint32, error) {
+	x.mu.Lock()
+	a, ok := x.atoms[name]
+	x.mu.Unlock()
+	if ok {
+		return a, nil
+	}
+	rep, err := x.call(x11InternAtom, 1, x11String(name)) // only-if-exists
+	if err != nil {
+		return 0, err
+	}
+	a = x11Order.Uint32(rep[8:])
+	if a != 0 {
+		x.mu.Lock()
+		x.atoms[name] = a
+		x.mu.Unlock()
+	}
+	return a, nil
+}
+
+// property returns the value and type of prop on win; an absent property
+// has type 0.
+func (x *x11Conn) property(win, prop uint32) ([]byte, uint32, error) {
+	body := make([]byte, 20)
+	x11Order.PutUint32(body[0:], win)
+	x11Order.PutUint32(body[4:], prop)
+	x11Order.PutUint32(body[8:], 0)      // AnyPropertyType
+	x11Order.PutUint32(body[12:], 0)     // long-offset
+	x11Order.PutUint32(body[16:], 1<<16) // long-length
+	rep, err := x.call(x11GetProperty, 0, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	typ := x11Order.Uint32(rep[8:])
+	format := int(rep[1])
+	n := int(x11Order.Uint32(rep[16:])) * format / 8
+	if 32+n > len(rep) {
+		n = len(rep) - 32
+	}
+	return rep[32 : 32+n], typ, nil
+}
+
+// windowsProperty reads a list of window IDs such as _NET_CLIENT_LIST from
+// the root window. ok is false if the property is not set.
+func (x *x11Conn) windowsProperty(name string) ([]uint32, bool, error) {
+	return x.listProperty(x.root, name)
+}
+
+// listProperty reads a property of 32-bit values (window IDs, atoms or
+// cardinals) from win. ok is false if the property is not set.
+func (x *x11Conn) listProperty(win uint32, name string) ([]uint32, bool, error) {
+	a, err := x.atom(name)
+	if err != nil || a == 0 {
+		return nil, false, err
+	}
+	val, typ, err := x.property(win, a)
+	if err != nil || typ == 0 {
+		return nil, false, err
+	}
+	list := make([]uint32, len(val)/4)
+	for i := range list {
+		list[i] = x11Order.Uint32(val[4*i:])
+	}
+	return list, true, nil
+}
+
+func (x *x11Conn) queryTree(win uint32) ([]uint32, error) {
+	body := make([]byte, 4)
+	x11Order.PutUint32(body, win)
+	rep, err := x.call(x11QueryTree, 0, body)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, int(x11Order.Uint16(rep[16:])))
+	for i := range ids {
+		ids[i] = x11Order.Uint32(rep[32+4*i:])
+	}
+	return ids, nil
+}
+
+// mapState returns 0 unmapped, 1 unviewable or 2 viewable.
+func (x *x11Conn) mapState(win uint32) (byte, error) {
+	body := make([]byte, 4)
+	x11Order.PutUint32(body, win)
+	rep, err := x.call(x11GetWindowAttributes, 0, body)
+	if err != nil {
+		return 0, err
+	}
+	return rep[26], nil
+}
+
+// geometry returns win's rectangle in root window coordinates.
+func (x *x11Conn) geometry(win uint32) (Rect, error) {
+	body := make([]byte, 4)
+	x11Order.PutUint32(body, win)
+	rep, err := x.call(x11GetGeometry, 0, body)
+	if err != nil {
+		return Rect{}, err
+	}
+	width := int32(x11Order.Uint16(rep[16:]))
+	height := int32(x11Order.Uint16(rep[18:]))
+
+	body = make([]byte, 12)
+	x11Order.PutUint32(body[0:], win)
+	x11Order.PutUint32(body[4:], x.root)
+	rep, err = x.call(x11TranslateCoordinates, 0, body)
+	if err != nil {
+		return Rect{}, err
+	}
+	left := int32(int16(x11Order.Uint16(rep[12:])))
+	top := int32(int16(x11Order.Uint16(rep[14:])))
+	return Rect{Left: left, Top: top, Right: left + width, Bottom: top + height}, nil
+}
+
+func (x *x11Conn) inputFocus() (uint32, error) {
+	rep, err := x.call(x11GetInputFocus, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	return x11Order.Uint32(rep[8:]), nil
+}
+
+func (x *x11Conn) mapWindow(win uint32) error {
+	body := make([]byte, 4)
+	x11Order.PutUint32(body, win)
+	return x.do(x11MapWindow, 0, body)
+}
+
+// raise puts win at the top of the stacking order.
+func (x *x11Conn) raise(win uint32) error {
+	const stackMode, above = 0x40, 0
+	body := make([]byte, 12)
+	x11Order.PutUint32(body[0:], win)
+	x11Order.PutUint16(body[4:], stackMode)
+	x11Order.PutUint32(body[8:], above)
+	return x.do(x11ConfigureWindow, 0, body)
+}
+
+// configure moves and resizes win.
+func (x *x11Conn) configure(win uint32, r Rect) error {
+	const xywh = 0x000F
+	body := make([]byte, 24)
+	x11Order.PutUint32(body[0:], win)
+	x11Order.PutUint16(body[4:], xywh)
+	x11Order.PutUint32(body[8:], uint32(r.Left))
+	x11Order.PutUint32(body[12:], uint32(r.Top))
+	x11Order.PutUint32(body[16:], uint32(r.Right-r.Left))
+	x11Order.PutUint32(body[20:], uint32(r.Bottom-r.Top))
+	return x.do(x11ConfigureWindow, 0, body)
+}
+
+func (x *x11Conn) setInputFocus(win uint32) error {
+	const revertToParent = 2
+	body := make([]byte, 8)
+	x11Order.PutUint32(body[0:], win)
+	x11Order.PutUint32(body[4:], 0) // CurrentTime
+	return x.do(x11SetInputFocus, revertToParent, body)
+}
+
+// clientMessage sends a 32-bit format ClientMessage about win to the root
+// window, the way EWMH pagers ask the window manager to act.
+func (x *x11Conn) clientMessage(win, msgType uint32, data ...uint32) error {
+	const substructureMask = 0x00180000 // SubstructureNotify | SubstructureRedirect
+	return x.sendClientMessage(x.root, substructureMask, win, msgType, data...)
+}
+
+// sendClientMessage sends a 32-bit format ClientMessage about win to dest,
+// selecting the clients that listen for eventMask there (0 for the owner).
+func (x *x11Conn) sendClientMessage(dest, eventMask, win, msgType uint32, data ...uint32) error {
+	body := make([]byte, 40)
+	x11Order.PutUint32(body[0:], dest)
+	x11Order.PutUint32(body[4:], eventMask)
+	ev := body[8:]
+	ev[0] = x11ClientMessage
+	ev[1] = 32
+	x11Order.PutUint32(ev[4:], win)
+	x11Order.PutUint32(ev[8:], msgType)
+	for i, d := range data {
+		if i < 5 {
+			x11Order.PutUint32(ev[12+4*i:], d)
+		}
+	}
+	return x.do(x11SendEvent, 0, body)
+}
+
+// fakeKey presses or releases keycode through XTEST.
+func (x *x11Conn) fakeKey(keycode byte, press bool) error {
+	typ := byte(x11KeyRelease)
+	if press {
+		typ = x11KeyPress
+	}
+	body := make([]byte, 32)
+	body[0] = typ
+	body[1] = keycode
+	return x.do(x.xtestOpcode, xtestFakeInput, body)
+}
+
+// fakeButton presses or releases pointer button through XTEST.
+func (x *x11Conn) fakeButton(button byte, press bool) error {
+	typ := byte(x11ButtonRelease)
+	if press {
+		typ = x11ButtonPress
+	}
+	body := make([]byte, 32)
+	body[0] = typ
+	body[1] = button
+	return x.do(x.xtestOpcode, xtestFakeInput, body)
+}
+
+// fakeMotion moves the pointer to rootX, rootY through XTEST.
+func (x *x11Conn) fakeMotion(rootX, rootY int32) error {
+	body := make([]byte, 32)
+	body[0] = x11MotionNotify
+	body[1] = 0 // absolute
+	x11Order.PutUint32(body[8:], x.root)
+	x11Order.PutUint16(body[20:], uint16(int16(rootX)))
+	x11Order.PutUint16(body[22:], uint16(int16(rootY)))
+	return x.do(x.xtestOpcode, xtestFakeInput, body)
+}
+
+// x11Key is where a keysym sits on the keyboard: its keycode, and whether
+// it is in the shifted column.
+type x11Key struct {
+	code  byte
+	shift bool
+}
+
+// keyboardMapping returns the key that produces each keysym from the first
+// two columns, preferring the unshifted one, and a spare keycode with no
+// keysyms at all (0 if there is none) that can be borrowed to type others.
+func (x *x11Conn) keyboardMapping() (map[uint32]x11Key, byte, error) {
+	count := int(x.maxKeycode) - int(x.minKeycode) + 1
+	body := []byte{x.minKeycode, byte(count), 0, 0}
+	rep, err := x.call(x11GetKeyboardMapping, 0, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	perCode := int(rep[1])
+	syms := rep[32:]
+	keys := map[uint32]x11Key{}
+	var spare byte
+	for i := 0; i < count && 4*(i+1)*perCode <= len(syms); i++ {
+		empty := true
+		for col := 0; col < perCode; col++ {
+			sym := x11Order.Uint32(syms[4*(i*perCode+col):])
+			if sym == 0 {
+				continue
+			}
+			empty = false
+			if col > 1 {
+				continue
+			}
+			k := x11Key{code: x.minKeycode + byte(i), shift: col == 1}
+			if prev, seen := keys[sym]; !seen || (prev.shift && !k.shift) {
+				keys[sym] = k
+			}
+		}
+		if empty {
+			spare = x.minKeycode + byte(i)
+		}
+	}
+	return keys, spare, nil
+}
+
+// setKeysym makes keycode produce sym, shifted or not.
+func (x *x11Conn) setKeysym(keycode byte, sym uint32) error {
+	body := make([]byte, 12)
+	body[0] = keycode
+	body[1] = 2 // keysyms per keycode
+	x11Order.PutUint32(body[4:], sym)
+	x11Order.PutUint32(body[8:], sym)
+	return x.do(x11ChangeKeyboardMapping, 1, body)
+}
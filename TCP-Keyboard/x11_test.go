@@ -0,0 +1,367 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDisplay(t *testing.T) {
+	cases := []struct {
+		display               string
+		network, addr, number string
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", "0"},
+		{":1.0", "unix", "/tmp/.X11-unix/X1", "1"},
+		{"unix:2", "unix", "/tmp/.X11-unix/X2", "2"},
+		{"localhost:10.0", "tcp", "localhost:6010", "10"},
+		{"/private/tmp/com.apple.launchd.x/org.xquartz:0", "unix", "/private/tmp/com.apple.launchd.x/org.xquartz:0", "0"},
+	}
+	for _, c := range cases {
+		network, addr, number, err := parseDisplay(c.display)
+		if err != nil || network != c.network || addr != c.addr || number != c.number {
+			t.Errorf("parseDisplay(%q) = %q, %q, %q, %v; want %q, %q, %q",
+				c.display, network, addr, number, err, c.network, c.addr, c.number)
+		}
+	}
+	for _, bad := range []string{"", "0", ":x", "host:"} {
+		if _, _, _, err := parseDisplay(bad); err == nil {
+			t.Errorf("parseDisplay(%q) accepted", bad)
+		}
+	}
+}
+
+func TestReadXauthority(t *testing.T) {
+	hostname, _ := os.Hostname()
+	entry := func(family uint16, addr, number, name string, data []byte) []byte {
+		b := binary.BigEndian.AppendUint16(nil, family)
+		for _, f := range [][]byte{[]byte(addr), []byte(number), []byte(name), data} {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+			b = append(b, f...)
+		}
+		return b
+	}
+	var file []byte
+	file = append(file, entry(256, hostname, "0", "XDM-AUTHORIZATION-1", []byte("skip"))...)
+	file = append(file, entry(256, "otherhost", "1", "MIT-MAGIC-COOKIE-1", []byte("fallback"))...)
+	file = append(file, entry(256, hostname, "1", "MIT-MAGIC-COOKIE-1", []byte("local"))...)
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XAUTHORITY", path)
+
+	if name, data := readXauthority("1"); name != "MIT-MAGIC-COOKIE-1" || string(data) != "local" {
+		t.Errorf("display 1: %q %q, want this host's cookie", name, data)
+	}
+	if name, data := readXauthority("0"); name != "" || data != nil {
+		t.Errorf("display 0: %q %q, want no cookie", name, data)
+	}
+}
+
+// fakeXServer answers the handful of requests TestX11Wire makes, over one
+// end of a pipe, in the order the protocol defines its packets.
+type fakeXServer struct {
+	t      *testing.T
+	r      *bufio.Reader
+	w      io.Writer
+	seq    uint16
+	xtest  chan []byte // XTEST FakeInput bodies
+	xtestO byte
+}
+
+const (
+	fakeRoot   = 0x2a
+	fakeChild1 = 0x400001
+	fakeChild2 = 0x400002
+)
+
+func (s *fakeXServer) setup() {
+	var req [12]byte
+	if _, err := io.ReadFull(s.r, req[:]); err != nil {
+		s.t.Error(err)
+		return
+	}
+	n := int(x11Order.Uint16(req[6:]))
+	d := int(x11Order.Uint16(req[8:]))
+	io.CopyN(io.Discard, s.r, int64(n+pad4(n)+d+pad4(d)))
+
+	vendor := "Fake"
+	body := make([]byte, 32, 128)
+	x11Order.PutUint32(body[4:], 0x400000) // resource-id-base
+	x11Order.PutUint16(body[16:], uint16(len(vendor)))
+	body[20], body[21] = 1, 1 // screens, formats
+	body[26], body[27] = 8, 255
+	body = append(body, vendor...)
+	body = append(body, make([]byte, 8)...) // one pixmap format
+	screen := make([]byte, 40)
+	x11Order.PutUint32(screen, fakeRoot)
+	body = append(body, screen...)
+	hdr := make([]byte, 8)
+	hdr[0] = 1
+	x11Order.PutUint16(hdr[2:], 11)
+	x11Order.PutUint16(hdr[6:], uint16(len(body)/4))
+	s.w.Write(append(hdr, body...))
+}
+
+// reply writes a reply with data after the 32-byte header.
+func (s *fakeXServer) reply(b1 byte, fields []byte, data []byte) {
+	rep := make([]byte, 32, 32+len(data))
+	rep[0], rep[1] = 1, b1
+	x11Order.PutUint16(rep[2:], s.seq)
+	x11Order.PutUint32(rep[4:], uint32(len(data)/4))
+	copy(rep[8:], fields)
+	s.w.Write(append(rep, data...))
+}
+
+func (s *fakeXServer) serve() {
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+			return
+		}
+		body := make([]byte, int(x11Order.Uint16(hdr[2:]))*4-4)
+		io.ReadFull(s.r, body)
+		s.seq++
+
+		switch hdr[0] {
+		case x11QueryExtension:
+			s.reply(0, []byte{1, s.xtestO}, nil)
+		case x11QueryTree:
+			// An event and a generic event arrive first and must be skipped
+			ev := make([]byte, 32)
+			ev[0] = x11KeyPress
+			s.w.Write(ev)
+			gen := make([]byte, 36)
+			gen[0] = 35
+			x11Order.PutUint32(gen[4:], 1)
+			s.w.Write(gen)
+
+			fields := make([]byte, 10)
+			x11Order.PutUint32(fields[0:], fakeRoot)
+			x11Order.PutUint16(fields[8:], 2)
+			children := make([]byte, 8)
+			x11Order.PutUint32(children[0:], fakeChild1)
+			x11Order.PutUint32(children[4:], fakeChild2)
+			s.reply(0, fields, children)
+		case x11GetProperty:
+			value := "Sim — Ready"
+			fields := make([]byte, 12)
+			x11Order.PutUint32(fields[0:], 31) // STRING
+			x11Order.PutUint32(fields[8:], uint32(len(value)))
+			s.reply(8, fields, append([]byte(value), make([]byte, pad4(len(value)))...))
+		case x11GetWindowAttributes:
+			// BadWindow
+			e := make([]byte, 32)
+			e[1] = 3
+			x11Order.PutUint16(e[2:], s.seq)
+			e[10] = x11GetWindowAttributes
+			s.w.Write(e)
+		case s.xtestO:
+			s.xtest <- body
+		}
+	}
+}
+
+func TestX11RuneKeystroke(t *testing.T) {
+	// A German keyboard: z where US has y and the reverse, " on shift+2,
+	// é nowhere. Each key is sent at its own position, so VK Z goes out at
+	// the scan code of US y.
+	keys := map[uint32]x11Key{
+		'y': {code: 29 + 23}, 'z': {code: 29}, 'Z': {code: 29, shift: true},
+		'2': {code: 11}, '"': {code: 11, shift: true},
+		0x01000000 | 0x20ac: {code: 200}, // € on a keycode no virtual key reaches
+	}
+	b := &x11Backend{keys: keys, codeVK: keycodeVKs(keys)}
+
+	for _, c := range []struct {
+		r    rune
+		want Keystroke
+	}{
+		{'z', Keystroke{VK: 'Z', Scan: 21}},
+		{'Z', Keystroke{VK: 'Z', Scan: 21, Shift: true}},
+		{'y', Keystroke{VK: 'Y', Scan: 44}},
+		{'"', Keystroke{VK: '2', Scan: 3, Shift: true}},
+	} {
+		if got, ok := b.RuneKeystroke(c.r); !ok || got != c.want {
+			t.Errorf("%q: got %+v, %v, want %+v", c.r, got, ok, c.want)
+		}
+	}
+	for _, r := range []rune{'é', '€'} {
+		if ks, ok := b.RuneKeystroke(r); ok {
+			t.Errorf("%q: got %+v, want none so TypeRune types it", r, ks)
+		}
+	}
+}
+
+// TestX11Wire checks the client's encoding and decoding against a scripted
+// server: the handshake, replies with events in front of them, errors, and
+// XTEST requests.
+func TestX11Wire(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &fakeXServer{t: t, r: bufio.NewReader(server), w: server, xtest: make(chan []byte, 4), xtestO: 140}
+	go func() {
+		s.setup()
+		s.serve()
+	}()
+
+	x := &x11Conn{conn: client, r: bufio.NewReader(client), atoms: map[string]uint32{}}
+	if err := x.setup("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if x.root != fakeRoot || x.idBase != 0x400000 || x.minKeycode != 8 || x.maxKeycode != 255 {
+		t.Errorf("setup: root %#x id base %#x keycodes %d-%d", x.root, x.idBase, x.minKeycode, x.maxKeycode)
+	}
+	op, ok, err := x.queryExtension("XTEST")
+	if err != nil || !ok || op != s.xtestO {
+		t.Fatalf("queryExtension = %d, %v, %v", op, ok, err)
+	}
+	x.xtestOpcode = op
+
+	ids, err := x.queryTree(x.root)
+	if err != nil || !reflect.DeepEqual(ids, []uint32{fakeChild1, fakeChild2}) {
+		t.Errorf("queryTree = %#x, %v", ids, err)
+	}
+	val, typ, err := x.property(fakeChild1, x11AtomWMName)
+	if err != nil || typ != 31 || string(val) != "Sim — Ready" {
+		t.Errorf("property = %q, %d, %v", val, typ, err)
+	}
+	if _, err := x.mapState(0xdead); err != (x11Error{Code: 3, Opcode: x11GetWindowAttributes}) {
+		t.Errorf("mapState of a bad window: %v", err)
+	}
+
+	if err := x.fakeKey(38, true); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-s.xtest; body[0] != x11KeyPress || body[1] != 38 {
+		t.Errorf("fakeKey sent % x", body[:2])
+	}
+	if err := x.fakeMotion(300, -5); err != nil {
+		t.Fatal(err)
+	}
+	body := <-s.xtest
+	if body[0] != x11MotionNotify || x11Order.Uint32(body[8:]) != fakeRoot ||
+		int16(x11Order.Uint16(body[20:])) != 300 || int16(x11Order.Uint16(body[22:])) != -5 {
+		t.Errorf("fakeMotion sent % x", body)
+	}
+}
+
+// TestX11Display runs the backend against a real X server, such as
+// "Xvfb :99 & DISPLAY=:99 go test". It maps a window of its own, lists it,
+// focuses it and checks the keys sent to it arrive.
+func TestX11Display(t *testing.T) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		t.Skip("DISPLAY is not set")
+	}
+	b, err := newX11Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := backend
+	backend = b
+	t.Cleanup(func() { backend = saved })
+
+	// A second connection plays the application
+	app, err := dialX11(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.conn.Close()
+	win := app.idBase | 1
+	const keyPressMask, keyReleaseMask = 1, 2
+	create := make([]byte, 32)
+	x11Order.PutUint32(create[0:], win)
+	x11Order.PutUint32(create[4:], app.root)
+	x11Order.PutUint16(create[8:], 10)  // x
+	x11Order.PutUint16(create[10:], 10) // y
+	x11Order.PutUint16(create[12:], 200)
+	x11Order.PutUint16(create[14:], 100)
+	x11Order.PutUint16(create[18:], 1)      // InputOutput
+	x11Order.PutUint32(create[24:], 0x0800) // CWEventMask
+	x11Order.PutUint32(create[28:], keyPressMask|keyReleaseMask)
+	if err := app.do(1, 0, create); err != nil { // CreateWindow
+		t.Fatal(err)
+	}
+	defer func() {
+		destroy := make([]byte, 4)
+		x11Order.PutUint32(destroy, win)
+		app.do(4, 0, destroy) // DestroyWindow
+	}()
+	setString := func(prop uint32, value string) {
+		body := make([]byte, 20, 20+len(value)+3)
+		x11Order.PutUint32(body[0:], win)
+		x11Order.PutUint32(body[4:], prop)
+		x11Order.PutUint32(body[8:], 31) // STRING
+		body[12] = 8
+		x11Order.PutUint32(body[16:], uint32(len(value)))
+		body = append(body, value...)
+		body = append(body, make([]byte, pad4(len(value)))...)
+		if err := app.do(18, 0, body); err != nil { // ChangeProperty, replace
+			t.Fatal(err)
+		}
+	}
+	title := fmt.Sprintf("TCP-Keyboard test %d", os.Getpid())
+	setString(x11AtomWMName, title)
+	setString(x11AtomWMClass, "kbtest\x00KbTest\x00")
+	if err := app.mapWindow(win); err != nil {
+		t.Fatal(err)
+	}
+
+	var found Window
+	for deadline := time.Now().Add(3 * time.Second); found.Handle == 0 && time.Now().Before(deadline); {
+		windows, err := b.Windows.Windows()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range windows {
+			if w.Title == title && w.Visible {
+				found = w
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if found.Handle == 0 {
+		t.Fatalf("window %q never listed as visible", title)
+	}
+	if found.Class != "KbTest" {
+		t.Errorf("class %q, want KbTest", found.Class)
+	}
+
+	resp := call(t, fmt.Sprintf(`{"action":"keypress","match":{"title":%q},"keys":["a"],%s}`, title, fast))
+	wantStatus(t, resp, "success")
+
+	a := b.Keys.(*x11Backend).keys[0x61] // keysym a
+	var got []string
+	app.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(got) < 2 {
+		var ev [32]byte
+		if _, err := io.ReadFull(app.r, ev[:]); err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		switch ev[0] & 0x7f {
+		case 0:
+			t.Fatalf("X11 error %d for request %d", ev[1], ev[10])
+		case 1, 35:
+			io.CopyN(io.Discard, app.r, int64(x11Order.Uint32(ev[4:]))*4)
+		case x11KeyPress, x11KeyRelease:
+			if x11Order.Uint32(ev[12:]) == win {
+				got = append(got, fmt.Sprintf("%d:%d", ev[0]&0x7f, ev[1]))
+			}
+		}
+	}
+	want := []string{fmt.Sprintf("%d:%d", x11KeyPress, a.code), fmt.Sprintf("%d:%d", x11KeyRelease, a.code)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("window got key events %v, want %v", got, want)
+	}
+}